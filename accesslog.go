@@ -0,0 +1,371 @@
+package coral
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral/config"
+	. "github.com/coral/log"
+)
+
+// 访问日志格式
+const (
+	ACCESS_LOG_TEXT   = iota // <- latency host path params -> status ...
+	ACCESS_LOG_JSON          // 一行一个json对象
+	ACCESS_LOG_APACHE        // apache combined格式，末尾追加耗时(微秒)
+)
+
+// 访问日志可选字段
+const (
+	FIELD_TIME        = "time"
+	FIELD_REQUEST_ID  = "request_id"
+	FIELD_METHOD      = "method"
+	FIELD_HOST        = "host"
+	FIELD_PATH        = "path"
+	FIELD_HTTP_STATUS = "http_status"
+	FIELD_STATUS      = "status"
+	FIELD_ERRMSG      = "errmsg"
+	FIELD_BYTES       = "bytes"
+	FIELD_LATENCY     = "latency"
+	FIELD_CLIENT_IP   = "client_ip"
+	FIELD_USER_AGENT  = "user_agent"
+	FIELD_REFERER     = "referer"
+	FIELD_PARAMS      = "params"
+	FIELD_DATA        = "data"
+)
+
+// AccessLog 是访问日志的配置
+// 指定Logger或Writer时日志原样输出，不带时间，级别和请求id等前缀
+// 都没有指定时，text格式通过Info输出到所有logger，json和apache格式输出到标准输出
+type AccessLog struct {
+	Format     int       // 日志格式，ACCESS_LOG_*
+	Fields     []string  // json格式输出的字段，为空时输出全部字段
+	Redact     []string  // 需要隐藏的参数名，参数名包含其中任意一个时隐藏，不区分大小写
+	MaxPayload int       // params和data输出的最大长度，超过的部分截断，为0时不截断
+	Logger     string    // 输出到日志池中指定的logger
+	Writer     io.Writer // 没有指定Logger时输出到Writer
+
+	writeMux sync.Mutex
+}
+
+// DefaultAccessLog 是server没有指定访问日志配置时使用的配置
+var DefaultAccessLog = &AccessLog{
+	Format:     ACCESS_LOG_TEXT,
+	Redact:     []string{"password", "passwd", "pwd", "secret", "token"},
+	MaxPayload: 1024}
+
+var allAccessLogFields = []string{
+	FIELD_TIME,
+	FIELD_REQUEST_ID,
+	FIELD_METHOD,
+	FIELD_HOST,
+	FIELD_PATH,
+	FIELD_HTTP_STATUS,
+	FIELD_STATUS,
+	FIELD_ERRMSG,
+	FIELD_BYTES,
+	FIELD_LATENCY,
+	FIELD_CLIENT_IP,
+	FIELD_USER_AGENT,
+	FIELD_REFERER,
+	FIELD_PARAMS,
+	FIELD_DATA}
+
+// NewAccessLog 从配置中读取访问日志配置，多个值用逗号分隔
+// [access_log]
+// FORMAT = json ; text, json, apache
+// FIELDS = time,method,path,http_status,latency
+// REDACT = password,token ; 为空时使用DefaultAccessLog.Redact
+// MAX_PAYLOAD = 512
+// LOGGER = access.log
+func NewAccessLog(conf config.Configer, group string) *AccessLog {
+	accessLog := &AccessLog{
+		Fields:     splitConfig(conf.Get(group + ".FIELDS")),
+		Redact:     splitConfig(conf.Get(group + ".REDACT")),
+		MaxPayload: conf.Int(group + ".MAX_PAYLOAD"),
+		Logger:     conf.Get(group + ".LOGGER")}
+	switch strings.ToLower(conf.Get(group + ".FORMAT")) {
+	case "json":
+		accessLog.Format = ACCESS_LOG_JSON
+	case "apache":
+		accessLog.Format = ACCESS_LOG_APACHE
+	default:
+		accessLog.Format = ACCESS_LOG_TEXT
+	}
+	if len(accessLog.Redact) < 1 {
+		accessLog.Redact = DefaultAccessLog.Redact
+	}
+	return accessLog
+}
+
+// SetAccessLog 指定server的访问日志配置
+func (server *Server) SetAccessLog(accessLog *AccessLog) {
+	server.accessLog = accessLog
+}
+
+// accessLog 输出请求的访问日志
+func (router *Router) accessLog(context *Context) {
+	accessLog := DefaultAccessLog
+	if router.server != nil && router.server.accessLog != nil {
+		accessLog = router.server.accessLog
+	}
+	var line string
+	switch accessLog.Format {
+	case ACCESS_LOG_JSON:
+		line = accessLog.json(context)
+	case ACCESS_LOG_APACHE:
+		line = accessLog.apache(context)
+	default:
+		line = accessLog.text(context)
+	}
+	accessLog.write(line)
+}
+
+// write 输出一行访问日志
+func (accessLog *AccessLog) write(line string) {
+	if accessLog.Logger != "" {
+		if logger, ok := Log.Pool[accessLog.Logger]; ok {
+			logger.Raw(line)
+			return
+		}
+		Error("access logger not found", accessLog.Logger)
+	}
+	writer := accessLog.Writer
+	if writer == nil {
+		if accessLog.Format == ACCESS_LOG_TEXT {
+			Info(line)
+			return
+		}
+		writer = os.Stdout
+	}
+	accessLog.writeMux.Lock()
+	defer accessLog.writeMux.Unlock()
+	if _, err := io.WriteString(writer, line+"\n"); err != nil {
+		Error("write access log error", err.Error())
+	}
+}
+
+// text 输出与原有访问日志一致的格式
+func (accessLog *AccessLog) text(context *Context) string {
+	fields := []interface{}{
+		"<-",
+		time.Now().Sub(context.startTime),
+		context.Host,
+		context.Path,
+		accessLog.params(context),
+		"->",
+		context.w.status}
+	if context.Raw {
+		fields = append(fields, context.w.size, accessLog.data(context))
+	} else {
+		fields = append(fields,
+			context.Status, accessLog.data(context), context.Errmsg)
+	}
+	return strings.TrimSuffix(fmt.Sprintln(fields...), "\n")
+}
+
+// json 输出指定字段的json
+func (accessLog *AccessLog) json(context *Context) string {
+	fields := accessLog.Fields
+	if len(fields) < 1 {
+		fields = allAccessLogFields
+	}
+	entry := make(map[string]interface{})
+	for _, field := range fields {
+		entry[field] = accessLog.field(context, field)
+	}
+	out, err := json.Marshal(entry)
+	if err != nil {
+		Error("access log marshal error", err.Error())
+		return accessLog.text(context)
+	}
+	return string(out)
+}
+
+// apache 输出apache combined格式
+func (accessLog *AccessLog) apache(context *Context) string {
+	req := context.req
+	bytes := "-"
+	if context.w.size > 0 {
+		bytes = strconv.FormatInt(context.w.size, 10)
+	}
+	return fmt.Sprintf(`%s - - [%s] "%s %s %s" %d %s "%s" "%s" %d`,
+		accessLog.field(context, FIELD_CLIENT_IP),
+		context.startTime.Format("02/Jan/2006:15:04:05 -0700"),
+		req.Method,
+		accessLog.requestURI(context),
+		req.Proto,
+		context.w.status,
+		bytes,
+		orDash(req.Referer()),
+		orDash(req.UserAgent()),
+		time.Now().Sub(context.startTime)/time.Microsecond)
+}
+
+// field 返回访问日志中一个字段的值
+func (accessLog *AccessLog) field(context *Context, field string) interface{} {
+	req := context.req
+	switch field {
+	case FIELD_TIME:
+		return context.startTime.Format(time.RFC3339Nano)
+	case FIELD_REQUEST_ID:
+		return context.RequestID
+	case FIELD_METHOD:
+		return req.Method
+	case FIELD_HOST:
+		return context.Host
+	case FIELD_PATH:
+		return req.URL.Path
+	case FIELD_HTTP_STATUS:
+		return context.w.status
+	case FIELD_STATUS:
+		if context.Raw {
+			return nil
+		}
+		return context.Status
+	case FIELD_ERRMSG:
+		return context.Errmsg
+	case FIELD_BYTES:
+		return context.w.size
+	case FIELD_LATENCY:
+		return float64(time.Now().Sub(context.startTime)) / float64(time.Millisecond)
+	case FIELD_CLIENT_IP:
+		return context.ClientIP()
+	case FIELD_USER_AGENT:
+		return req.UserAgent()
+	case FIELD_REFERER:
+		return req.Referer()
+	case FIELD_PARAMS:
+		return accessLog.params(context)
+	case FIELD_DATA:
+		return accessLog.data(context)
+	default:
+		return nil
+	}
+}
+
+// params 返回隐藏敏感参数并截断后的参数
+func (accessLog *AccessLog) params(context *Context) interface{} {
+	params := accessLog.redact(context.Params)
+	if accessLog.MaxPayload <= 0 {
+		return params
+	}
+	out, err := json.Marshal(params)
+	if err != nil {
+		return params
+	}
+	if len(out) <= accessLog.MaxPayload {
+		return params
+	}
+	return truncate(string(out), accessLog.MaxPayload)
+}
+
+// data 返回截断后的输出数据，Raw输出只记录类型
+func (accessLog *AccessLog) data(context *Context) interface{} {
+	if context.Raw {
+		var data string
+		switch body := context.Data.(type) {
+		case string:
+			data = body
+		case []byte:
+			data = string(body)
+		default:
+			data = fmt.Sprintf("%T", body)
+		}
+		if context.redirect != "" {
+			data = "redirect " + context.redirect
+		}
+		if context.file != nil {
+			data = "file " + context.file.path
+		}
+		return truncate(data, accessLog.MaxPayload)
+	}
+	if accessLog.MaxPayload <= 0 || context.Data == nil {
+		return context.Data
+	}
+	out, err := json.Marshal(context.Data)
+	if err != nil || len(out) <= accessLog.MaxPayload {
+		return context.Data
+	}
+	return truncate(string(out), accessLog.MaxPayload)
+}
+
+// redact 返回隐藏了敏感参数的副本，嵌套的参数也会处理
+func (accessLog *AccessLog) redact(params map[string]interface{}) map[string]interface{} {
+	if len(accessLog.Redact) < 1 || params == nil {
+		return params
+	}
+	ret := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		if accessLog.sensitive(key) {
+			ret[key] = "***"
+			continue
+		}
+		switch value := value.(type) {
+		case map[string]interface{}:
+			ret[key] = accessLog.redact(value)
+		case []interface{}:
+			list := make([]interface{}, len(value))
+			for i, ele := range value {
+				if m, ok := ele.(map[string]interface{}); ok {
+					list[i] = accessLog.redact(m)
+				} else {
+					list[i] = ele
+				}
+			}
+			ret[key] = list
+		default:
+			ret[key] = value
+		}
+	}
+	return ret
+}
+
+// requestURI 返回隐藏了敏感参数的请求地址
+func (accessLog *AccessLog) requestURI(context *Context) string {
+	uri := context.req.URL
+	if uri.RawQuery == "" || len(accessLog.Redact) < 1 {
+		return context.req.RequestURI
+	}
+	query := uri.Query()
+	for key := range query {
+		if accessLog.sensitive(key) {
+			query.Set(key, "***")
+		}
+	}
+	return uri.Path + "?" + query.Encode()
+}
+
+func (accessLog *AccessLog) sensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, name := range accessLog.Redact {
+		if strings.Contains(key, strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncate 将字符串截断为最多max字节，保证不截断utf8字符
+func truncate(str string, max int) string {
+	if max <= 0 || len(str) <= max {
+		return str
+	}
+	for max > 0 && (str[max]&0xc0) == 0x80 {
+		max--
+	}
+	return str[:max] + "...(" + strconv.Itoa(len(str)) + " bytes)"
+}
+
+func orDash(str string) string {
+	if str == "" {
+		return "-"
+	}
+	return str
+}
@@ -0,0 +1,156 @@
+package coral
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// accessLogLine 处理一个请求，返回输出的访问日志
+func accessLogLine(t *testing.T, accessLog *AccessLog, filter Filter, target string,
+	form url.Values) string {
+	buf := new(bytes.Buffer)
+	accessLog.Writer = buf
+	server := testServer(func(server *Server) {
+		server.SetAccessLog(accessLog)
+		server.NewRouter("/log", filter)
+	})
+	method := "GET"
+	if form != nil {
+		method = "POST"
+	}
+	req := testRequest(method, target, form)
+	req.Header.Set("User-Agent", "coral-test")
+	req.Header.Set("X-Request-ID", "req-1")
+	serveTest(server, req)
+	return buf.String()
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	success := func(context *Context) bool {
+		context.Data = map[string]interface{}{"name": "coral"}
+		return true
+	}
+	tests := []struct {
+		name   string
+		log    *AccessLog
+		filter Filter
+		target string
+		form   url.Values
+		want   map[string]interface{}
+	}{
+		{"selected fields", &AccessLog{Format: ACCESS_LOG_JSON,
+			Fields: []string{FIELD_REQUEST_ID, FIELD_METHOD, FIELD_PATH, FIELD_HTTP_STATUS,
+				FIELD_STATUS, FIELD_USER_AGENT}},
+			success, "/log?a=1", nil,
+			map[string]interface{}{"request_id": "req-1", "method": "GET", "path": "/log",
+				"http_status": 200.0, "status": 0.0, "user_agent": "coral-test"}},
+		{"redact", &AccessLog{Format: ACCESS_LOG_JSON, Fields: []string{FIELD_PARAMS},
+			Redact: []string{"password", "token"}},
+			success, "/log", url.Values{
+				"user": {"u1"}, "Password": {"p"}, "data": {`{"access_token":"t","list":[{"token":"x","a":1}]}`}},
+			map[string]interface{}{"params": map[string]interface{}{
+				"user": "u1", "Password": "***", "data": map[string]interface{}{
+					"access_token": "***", "list": []interface{}{
+						map[string]interface{}{"token": "***", "a": 1.0}}}}}},
+		{"truncate data", &AccessLog{Format: ACCESS_LOG_JSON, Fields: []string{FIELD_DATA},
+			MaxPayload: 10},
+			success, "/log", nil,
+			map[string]interface{}{"data": `{"name":"c...(16 bytes)`}},
+		{"raw data", &AccessLog{Format: ACCESS_LOG_JSON, Fields: []string{FIELD_STATUS, FIELD_DATA,
+			FIELD_BYTES}},
+			func(context *Context) bool {
+				context.Raw = true
+				context.Data = "hello"
+				return true
+			}, "/log", nil,
+			map[string]interface{}{"status": nil, "data": "hello", "bytes": 5.0}},
+		{"errmsg", &AccessLog{Format: ACCESS_LOG_JSON, Fields: []string{FIELD_STATUS, FIELD_ERRMSG}},
+			func(context *Context) bool {
+				context.Status = STATUS_FORBIDDEN
+				context.Errmsg = "denied"
+				return false
+			}, "/log", nil,
+			map[string]interface{}{"status": float64(STATUS_FORBIDDEN), "errmsg": "denied"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line := accessLogLine(t, test.log, test.filter, test.target, test.form)
+			var entry map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("invalid json %q: %v", line, err)
+			}
+			want, _ := json.Marshal(test.want)
+			got, _ := json.Marshal(entry)
+			if string(got) != string(want) {
+				t.Errorf("entry = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	filter := func(context *Context) bool {
+		context.Data = "ok"
+		return true
+	}
+	tests := []struct {
+		name    string
+		log     *AccessLog
+		target  string
+		pattern string
+	}{
+		{"apache", &AccessLog{Format: ACCESS_LOG_APACHE, Redact: []string{"token"}},
+			"/log?token=abc&a=1",
+			`^192\.0\.2\.1 - - \[[^\]]+\] "GET /log\?a=1&token=%2A%2A%2A HTTP/1\.1" 200 \d+ "-" "coral-test" \d+\n$`},
+		{"apache without query", &AccessLog{Format: ACCESS_LOG_APACHE},
+			"/log",
+			`^192\.0\.2\.1 - - \[[^\]]+\] "GET /log HTTP/1\.1" 200 \d+ "-" "coral-test" \d+\n$`},
+		{"text", &AccessLog{Format: ACCESS_LOG_TEXT, Redact: []string{"token"}},
+			"/log?token=abc",
+			`^<- \S+ example\.com /log map\[token:\*\*\*\] -> 200 0 ok \n$`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line := accessLogLine(t, test.log, filter, test.target, nil)
+			if !regexp.MustCompile(test.pattern).MatchString(line) {
+				t.Errorf("line = %q, want match %s", line, test.pattern)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		str string
+		max int
+		ret string
+	}{
+		{"hello", 0, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 3, "hel...(5 bytes)"},
+		{"中文字", 4, "中...(9 bytes)"},
+		{"中文字", 6, "中文...(9 bytes)"},
+	}
+	for _, test := range tests {
+		if ret := truncate(test.str, test.max); ret != test.ret {
+			t.Errorf("truncate(%q, %d) = %q, want %q", test.str, test.max, ret, test.ret)
+		}
+	}
+}
+
+func TestAccessLogSensitive(t *testing.T) {
+	accessLog := &AccessLog{Redact: []string{"Secret"}}
+	for key, sensitive := range map[string]bool{
+		"secret": true, "client_SECRET": true, "name": false} {
+		if accessLog.sensitive(key) != sensitive {
+			t.Errorf("sensitive(%q) = %v", key, !sensitive)
+		}
+	}
+	if !strings.Contains(strings.Join(DefaultAccessLog.Redact, ","), "password") {
+		t.Errorf("DefaultAccessLog.Redact = %v", DefaultAccessLog.Redact)
+	}
+}
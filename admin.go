@@ -0,0 +1,205 @@
+package coral
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"github.com/coral/config"
+	. "github.com/coral/log"
+)
+
+// processStart 是进程的启动时间
+var processStart = time.Now()
+
+// routeInfo 是一个已注册路由的信息
+type routeInfo struct {
+	Path        string   `json:"path"`
+	Type        string   `json:"type"`
+	Doc         string   `json:"doc,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// loggerInfo 是一个logger的日志级别
+type loggerInfo struct {
+	MaxLevel int `json:"max_level"`
+	MinLevel int `json:"min_level"`
+}
+
+// NewAdminServer 创建一个管理server，用于诊断server的运行状态
+// host应该只监听本地地址或者内网端口，不要暴露在公网
+//
+// /debug/pprof/     pprof
+// /debug/goroutines 所有goroutine的调用栈
+// /debug/info       版本，构建和运行时信息
+// /debug/routes     server已注册的路由
+// /debug/config     所有配置，敏感配置已隐藏
+// /debug/log        查看日志级别，POST name，max，min修改日志级别
+// /debug/health     健康检查的详情，包括每个检查的耗时和错误
+// /metrics          server开启EnableMetrics后的prometheus统计数据
+func NewAdminServer(host string, server *Server) *Server {
+	admin := NewServer(host)
+	registerPprof(admin.mux)
+	admin.mux.HandleFunc("/debug/goroutines", adminGoroutines)
+	admin.mux.HandleFunc("/debug/info", adminInfo)
+	admin.mux.HandleFunc("/debug/routes", func(w http.ResponseWriter, req *http.Request) {
+		var routes []*routeInfo
+		for _, router := range server.routers {
+			routes = appendRoutes(routes, router)
+		}
+		writeJSON(w, http.StatusOK, routes)
+	})
+	admin.mux.HandleFunc("/debug/config", adminConfig)
+	admin.mux.HandleFunc("/debug/log", adminLog)
+	admin.mux.HandleFunc("/debug/health", func(w http.ResponseWriter, req *http.Request) {
+		writeHealth(w, server.checkHealth())
+	})
+	admin.mux.HandleFunc(DefaultMetricsPath, server.serveMetrics)
+	return admin
+}
+
+func adminGoroutines(w http.ResponseWriter, req *http.Request) {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(buf)
+}
+
+func adminInfo(w http.ResponseWriter, req *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	info := map[string]interface{}{
+		"pid":          os.Getpid(),
+		"go_version":   runtime.Version(),
+		"start_time":   processStart.Format(time.RFC3339),
+		"uptime":       time.Now().Sub(processStart).String(),
+		"goroutines":   runtime.NumGoroutine(),
+		"cpus":         runtime.NumCPU(),
+		"gomaxprocs":   runtime.GOMAXPROCS(0),
+		"heap_alloc":   mem.HeapAlloc,
+		"heap_sys":     mem.HeapSys,
+		"heap_objects": mem.HeapObjects,
+		"num_gc":       mem.NumGC,
+		"pause_total":  time.Duration(mem.PauseTotalNs).String()}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		settings := make(map[string]string)
+		for _, setting := range build.Settings {
+			settings[setting.Key] = setting.Value
+		}
+		deps := make(map[string]string)
+		for _, dep := range build.Deps {
+			deps[dep.Path] = dep.Version
+		}
+		info["build"] = map[string]interface{}{
+			"path":     build.Path,
+			"main":     build.Main.Path + " " + build.Main.Version,
+			"settings": settings,
+			"deps":     deps}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// appendRoutes 递归添加router及其子路由的信息
+func appendRoutes(routes []*routeInfo, router *Router) []*routeInfo {
+	route := &routeInfo{Path: router.path, Type: "json"}
+	if router.doc != nil {
+		route.Description = router.doc.Description
+		route.Roles = router.doc.Roles
+		route.Permissions = router.doc.Permissions
+		if router.doc.kind != "" {
+			route.Type = router.doc.kind
+		}
+	}
+	if router.docHandler != nil {
+		route.Doc = router.docPath
+	}
+	routes = append(routes, route)
+	for _, child := range router.routers {
+		routes = appendRoutes(routes, child)
+	}
+	return routes
+}
+
+// allConfiger 是可以列出所有配置项的Configer，IniConfiger实现了该接口
+// 不放在config.Configer中，避免已有的Configer实现需要增加方法
+type allConfiger interface {
+	All() map[string]string
+}
+
+// configAll 返回所有配置项，key为group.element，conf不支持时返回nil
+func configAll(conf config.Configer) map[string]string {
+	if all, ok := conf.(allConfiger); ok {
+		return all.All()
+	}
+	Error("config does not support listing all items", fmt.Sprintf("%T", conf))
+	return nil
+}
+
+func adminConfig(w http.ResponseWriter, req *http.Request) {
+	ret := make(map[string]map[string]string)
+	for name, configer := range config.Config.Pool {
+		all := configAll(configer)
+		if all == nil {
+			continue
+		}
+		for key, value := range all {
+			all[key] = config.Mask(key, value)
+		}
+		ret[name] = all
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+// adminLog 查看所有logger的日志级别，POST时修改指定logger的日志级别
+func adminLog(w http.ResponseWriter, req *http.Request) {
+	if req.Method == "POST" {
+		name := req.FormValue("name")
+		logger, ok := Log.Pool[name]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, "logger not found: "+name)
+			return
+		}
+		maxLevel, err := strconv.Atoi(req.FormValue("max"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, "invalid max level")
+			return
+		}
+		minLevel, err := strconv.Atoi(req.FormValue("min"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, "invalid min level")
+			return
+		}
+		Info("set log level", name, maxLevel, minLevel)
+		logger.SetLevel(maxLevel, minLevel)
+	}
+	ret := make(map[string]*loggerInfo, len(Log.Pool))
+	for name, logger := range Log.Pool {
+		maxLevel, minLevel := logger.Level()
+		ret[name] = &loggerInfo{MaxLevel: maxLevel, MinLevel: minLevel}
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	out, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		Error(err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(out)
+}
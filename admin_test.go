@@ -0,0 +1,53 @@
+package coral
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAdminPprof(t *testing.T) {
+	admin := NewAdminServer("", testServer(func(server *Server) {}))
+	tests := []struct {
+		name   string
+		target string
+		code   int
+		ctype  string
+		body   string
+	}{
+		{"index", "/debug/pprof/", http.StatusOK, "text/html; charset=utf-8", "goroutine?debug=1"},
+		{"text profile", "/debug/pprof/goroutine?debug=1", http.StatusOK,
+			"text/plain; charset=utf-8", "goroutine profile:"},
+		{"binary profile", "/debug/pprof/heap", http.StatusOK, "application/octet-stream", ""},
+		{"unknown profile", "/debug/pprof/missing", http.StatusNotFound, "", "unknown profile"},
+		{"cmdline", "/debug/pprof/cmdline", http.StatusOK, "text/plain; charset=utf-8", ".test"},
+		{"cpu profile", "/debug/pprof/profile?seconds=0.01", http.StatusOK,
+			"application/octet-stream", ""},
+		{"trace", "/debug/pprof/trace?seconds=0.01", http.StatusOK,
+			"application/octet-stream", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := serveTest(admin, testRequest("GET", test.target, nil))
+			if resp.Code != test.code {
+				t.Fatalf("code = %d, want %d", resp.Code, test.code)
+			}
+			if ctype := resp.Header().Get("Content-Type"); test.ctype != "" && ctype != test.ctype {
+				t.Errorf("Content-Type = %q, want %q", ctype, test.ctype)
+			}
+			if !strings.Contains(resp.Body.String(), test.body) {
+				t.Errorf("body does not contain %q", test.body)
+			}
+			if test.code == http.StatusOK && resp.Body.Len() == 0 {
+				t.Errorf("empty body")
+			}
+		})
+	}
+
+	// pprof只注册在管理server上
+	req := &http.Request{Method: "GET", URL: &url.URL{Path: "/debug/pprof/"}}
+	if _, pattern := http.DefaultServeMux.Handler(req); pattern != "" {
+		t.Errorf("pprof registered on http.DefaultServeMux: %q", pattern)
+	}
+}
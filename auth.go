@@ -0,0 +1,524 @@
+package coral
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral/config"
+	"github.com/coral/db"
+	. "github.com/coral/log"
+)
+
+// 认证方式，即Principal.Type
+const (
+	AUTH_JWT       = "jwt"
+	AUTH_API_KEY   = "api_key"
+	AUTH_SIGNATURE = "signature"
+)
+
+// Principal 是通过认证的调用方
+type Principal struct {
+	ID     string                 // 调用方id，jwt中为sub
+	Type   string                 // 认证方式
+	Roles  []string               // 调用方的角色
+	Claims map[string]interface{} // jwt的claims
+}
+
+// HasRole 判断调用方是否有指定角色
+func (principal *Principal) HasRole(role string) bool {
+	if principal == nil {
+		return false
+	}
+	for _, r := range principal.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal 返回通过认证的调用方，未认证时返回nil
+func (context *Context) Principal() *Principal {
+	return context.principal
+}
+
+// SetPrincipal 指定通过认证的调用方，用于自定义的认证filter
+// 自定义的认证filter需要通过RegisterAuthFilter注册
+func (context *Context) SetPrincipal(principal *Principal) {
+	context.principal = principal
+}
+
+// splitRoles 解析逗号或空格分隔的角色
+func splitRoles(roles string) []string {
+	return strings.FieldsFunc(roles, func(c rune) bool {
+		return c == ',' || c == ' '
+	})
+}
+
+// JWTAuth 是jwt认证，支持HS256和RS256
+// 通过key id(kid)支持多个key，轮换时先添加新key，旧token全部过期后再删除旧key
+type JWTAuth struct {
+	Issuer     string        // 为空时不检查iss
+	Audience   string        // 为空时不检查aud
+	Leeway     time.Duration // exp，nbf和iat允许的时钟误差
+	Param      string        // 请求头没有token时从该参数读取，为空时不读取参数
+	RolesClaim string        // 角色所在的claim，默认roles
+	Optional   bool          // 为true时没有token的请求也可以通过，但不会有Principal
+	Status     int           // 认证失败时返回的status，默认STATUS_UNAUTHORIZED
+
+	mux  *sync.RWMutex
+	keys map[string]*jwtKey
+}
+
+type jwtKey struct {
+	alg string
+	key interface{} // HS256为[]byte，RS256为*rsa.PublicKey
+}
+
+var (
+	errTokenMissing   = errors.New("token missing")
+	errTokenMalformed = errors.New("token malformed")
+	errTokenAlg       = errors.New("token algorithm not allowed")
+	errTokenKey       = errors.New("token key not found")
+	errTokenSignature = errors.New("token signature invalid")
+	errTokenExpired   = errors.New("token expired")
+	errTokenNotYet    = errors.New("token not valid yet")
+	errTokenIssuer    = errors.New("token issuer invalid")
+	errTokenAudience  = errors.New("token audience invalid")
+)
+
+// NewJWTAuth 从配置中读取jwt认证配置
+// [jwt]
+// ISSUER = coral
+// AUDIENCE = api
+// LEEWAY = 30 ; 秒
+// KEYS = k2,k1 ; 所有可用的key id
+// KEY_K2 = HS256:secret ; secret中不能有;和=
+// KEY_K1 = RS256:/path/to/public.pem
+func NewJWTAuth(conf config.Configer, group string) *JWTAuth {
+	auth := &JWTAuth{
+		Issuer:   conf.Get(group + ".ISSUER"),
+		Audience: conf.Get(group + ".AUDIENCE"),
+		Leeway:   time.Duration(conf.Int(group+".LEEWAY")) * time.Second,
+		mux:      new(sync.RWMutex)}
+	for _, kid := range splitConfig(conf.Get(group + ".KEYS")) {
+		value := conf.Get(group + ".KEY_" + kid)
+		i := strings.Index(value, ":")
+		if i < 0 {
+			Error("invalid jwt key config", kid)
+			continue
+		}
+		alg, key := strings.ToUpper(value[:i]), value[i+1:]
+		switch alg {
+		case "HS256":
+			auth.AddKey(kid, alg, []byte(key))
+		case "RS256":
+			publicKey, err := loadRSAPublicKey(key)
+			if err != nil {
+				Error("load jwt public key error", kid, key, err.Error())
+				continue
+			}
+			auth.AddKey(kid, alg, publicKey)
+		default:
+			Error("invalid jwt key algorithm", kid, alg)
+		}
+	}
+	return auth
+}
+
+// AddKey 添加一个验证token的key，HS256的key为[]byte，RS256的key为*rsa.PublicKey
+func (auth *JWTAuth) AddKey(kid, alg string, key interface{}) {
+	if auth.mux == nil {
+		auth.mux = new(sync.RWMutex)
+	}
+	auth.mux.Lock()
+	defer auth.mux.Unlock()
+	if auth.keys == nil {
+		auth.keys = make(map[string]*jwtKey)
+	}
+	auth.keys[kid] = &jwtKey{alg: strings.ToUpper(alg), key: key}
+}
+
+// RemoveKey 删除一个key
+func (auth *JWTAuth) RemoveKey(kid string) {
+	if auth.mux == nil {
+		return
+	}
+	auth.mux.Lock()
+	defer auth.mux.Unlock()
+	delete(auth.keys, kid)
+}
+
+// Filter 从Authorization: Bearer请求头或Param参数中读取token并验证
+func (auth *JWTAuth) Filter(context *Context) bool {
+	token := context.GetHeader("Authorization")
+	if len(token) > 7 && strings.EqualFold(token[:7], "Bearer ") {
+		token = strings.TrimSpace(token[7:])
+	} else {
+		token = ""
+	}
+	if token == "" && auth.Param != "" {
+		token = String(context.Params[auth.Param])
+	}
+	if token == "" && auth.Optional {
+		return true
+	}
+	principal, err := auth.Verify(token)
+	if err != nil {
+		Debug("jwt auth faild", err.Error())
+		context.SetHeader("WWW-Authenticate",
+			`Bearer error="invalid_token", error_description="`+err.Error()+`"`)
+		context.Status = authStatus(auth.Status)
+		return false
+	}
+	context.principal = principal
+	return true
+}
+
+// Verify 验证token，返回token对应的调用方
+func (auth *JWTAuth) Verify(token string) (*Principal, error) {
+	if token == "" {
+		return nil, errTokenMissing
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errTokenMalformed
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, errTokenMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errTokenMalformed
+	}
+	if header.Alg != "HS256" && header.Alg != "RS256" {
+		return nil, errTokenAlg
+	}
+	if !auth.verifySignature(header.Alg, header.Kid,
+		parts[0]+"."+parts[1], signature) {
+		return nil, errTokenSignature
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errTokenMalformed
+	}
+	if err := auth.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	principal := &Principal{
+		ID:     String(claims["sub"]),
+		Type:   AUTH_JWT,
+		Claims: claims}
+	rolesClaim := auth.RolesClaim
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	switch roles := claims[rolesClaim].(type) {
+	case string:
+		principal.Roles = splitRoles(roles)
+	case []interface{}:
+		for _, role := range roles {
+			principal.Roles = append(principal.Roles, String(role))
+		}
+	}
+	return principal, nil
+}
+
+// verifySignature 用kid对应的key验证签名，没有kid时尝试所有同算法的key
+// key的算法必须与token的alg一致，防止用公钥作为HS256的secret伪造token
+func (auth *JWTAuth) verifySignature(
+	alg, kid, signed string, signature []byte) bool {
+	if auth.mux == nil {
+		return false
+	}
+	auth.mux.RLock()
+	defer auth.mux.RUnlock()
+	var keys []*jwtKey
+	if kid != "" {
+		if key, ok := auth.keys[kid]; ok {
+			keys = append(keys, key)
+		}
+	} else {
+		for _, key := range auth.keys {
+			keys = append(keys, key)
+		}
+	}
+	hash := sha256.Sum256([]byte(signed))
+	for _, key := range keys {
+		if key.alg != alg {
+			continue
+		}
+		switch k := key.key.(type) {
+		case []byte:
+			mac := hmac.New(sha256.New, k)
+			mac.Write([]byte(signed))
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], signature) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkClaims 检查exp，nbf，iat，iss和aud
+func (auth *JWTAuth) checkClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(unixTime(exp).Add(auth.Leeway)) {
+		return errTokenExpired
+	}
+	if nbf, ok := claims["nbf"].(float64); ok &&
+		now.Add(auth.Leeway).Before(unixTime(nbf)) {
+		return errTokenNotYet
+	}
+	if iat, ok := claims["iat"].(float64); ok &&
+		now.Add(auth.Leeway).Before(unixTime(iat)) {
+		return errTokenNotYet
+	}
+	if auth.Issuer != "" && String(claims["iss"]) != auth.Issuer {
+		return errTokenIssuer
+	}
+	if auth.Audience != "" {
+		found := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			found = aud == auth.Audience
+		case []interface{}:
+			for _, a := range aud {
+				if String(a) == auth.Audience {
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return errTokenAudience
+		}
+	}
+	return nil
+}
+
+// SignJWT 生成jwt，HS256的key为[]byte，RS256的key为*rsa.PrivateKey
+// 用于登录接口签发token，以及调用其他服务
+func SignJWT(alg, kid string, key interface{},
+	claims map[string]interface{}) (string, error) {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		if alg != "HS256" {
+			return "", errTokenAlg
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		if alg != "RS256" {
+			return "", errTokenAlg
+		}
+		hash := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", errTokenKey
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
+
+// loadRSAPublicKey 读取pem格式的rsa公钥，支持PKIX和PKCS1格式
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid pem file")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not a rsa public key")
+	}
+	return publicKey, nil
+}
+
+func authStatus(status int) int {
+	if status == 0 {
+		return STATUS_UNAUTHORIZED
+	}
+	return status
+}
+
+// APIKeyStore 根据api key查找调用方，不存在时返回nil
+type APIKeyStore interface {
+	Lookup(key string) *Principal
+}
+
+// APIKeyAuth 是api key认证
+type APIKeyAuth struct {
+	Header   string // api key所在的请求头，默认X-API-Key
+	Param    string // 请求头没有api key时从该参数读取，为空时不读取参数
+	Optional bool   // 为true时没有api key的请求也可以通过，但不会有Principal
+	Status   int    // 认证失败时返回的status，默认STATUS_UNAUTHORIZED
+
+	store APIKeyStore
+}
+
+// NewAPIKeyAuth 创建使用store的api key认证
+func NewAPIKeyAuth(store APIKeyStore) *APIKeyAuth {
+	return &APIKeyAuth{Header: "X-API-Key", store: store}
+}
+
+// Filter 读取并验证api key
+func (auth *APIKeyAuth) Filter(context *Context) bool {
+	key := context.GetHeader(auth.Header)
+	if key == "" && auth.Param != "" {
+		key = String(context.Params[auth.Param])
+	}
+	if key == "" && auth.Optional {
+		return true
+	}
+	var principal *Principal
+	if key != "" {
+		principal = auth.store.Lookup(key)
+	}
+	if principal == nil {
+		Debug("api key auth faild")
+		context.Status = authStatus(auth.Status)
+		return false
+	}
+	principal.Type = AUTH_API_KEY
+	context.principal = principal
+	return true
+}
+
+// configAPIKeyStore 是保存在配置中的api key
+type configAPIKeyStore struct {
+	keys map[string]*Principal
+}
+
+// NewConfigAPIKeyStore 从配置中读取api key，配置项名称为调用方id，conf需要实现All，如IniConfiger
+// 名称加上_ROLES为调用方的角色，如
+// [api_key]
+// PARTNER_A = 3f0c6b2e9a
+// PARTNER_A_ROLES = partner,reader
+func NewConfigAPIKeyStore(conf config.Configer, group string) APIKeyStore {
+	store := &configAPIKeyStore{keys: make(map[string]*Principal)}
+	prefix := strings.ToLower(group) + "."
+	all := configAll(conf)
+	for name, key := range all {
+		if !strings.HasPrefix(name, prefix) ||
+			strings.HasSuffix(name, "_roles") || key == "" {
+			continue
+		}
+		store.keys[key] = &Principal{
+			ID:    strings.TrimPrefix(name, prefix),
+			Roles: splitRoles(all[name+"_roles"])}
+	}
+	return store
+}
+
+// Lookup 逐个比较所有key，避免通过耗时猜测key
+func (store *configAPIKeyStore) Lookup(key string) *Principal {
+	var found *Principal
+	for k, principal := range store.keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			found = principal
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	ret := *found
+	return &ret
+}
+
+// DefaultAPIKeyQuery 是db store默认的查询语句
+// key_hash为api key的sha256的16进制小写，查询结果需要有id和roles两列
+const DefaultAPIKeyQuery = "SELECT id, roles FROM api_key WHERE key_hash = ? AND enabled = 1"
+
+// dbAPIKeyStore 是保存在mysql中的api key
+type dbAPIKeyStore struct {
+	database string
+	query    string
+}
+
+// NewDBAPIKeyStore 创建从db.DB.Pool[database]查询api key的store
+// 表中只保存api key的sha256，query为空时使用DefaultAPIKeyQuery
+func NewDBAPIKeyStore(database, query string) APIKeyStore {
+	if query == "" {
+		query = DefaultAPIKeyQuery
+	}
+	return &dbAPIKeyStore{database: database, query: query}
+}
+
+func (store *dbAPIKeyStore) Lookup(key string) *Principal {
+	hash := sha256.Sum256([]byte(key))
+	rows := db.Select(store.database, store.query, hex.EncodeToString(hash[:]))
+	if len(rows) < 1 {
+		return nil
+	}
+	principal := &Principal{ID: String(rows[0]["id"])}
+	// NULL的列值为"null"
+	if roles := String(rows[0]["roles"]); roles != "null" {
+		principal.Roles = splitRoles(roles)
+	}
+	return principal
+}
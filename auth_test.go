@@ -0,0 +1,160 @@
+package coral
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unsignedToken 返回alg为none的token
+func unsignedToken(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func TestJWTVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 用公钥作为HS256的secret伪造token
+	publicDER := x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)
+
+	auth := &JWTAuth{Issuer: "coral", Audience: "api", Leeway: 30 * time.Second}
+	auth.AddKey("hs", "HS256", testJWTSecret)
+	auth.AddKey("rs", "RS256", &rsaKey.PublicKey)
+
+	now := time.Now()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		ret := map[string]interface{}{
+			"sub":   "u1",
+			"iss":   "coral",
+			"aud":   "api",
+			"roles": "admin,editor",
+			"exp":   now.Add(time.Hour).Unix()}
+		for key, value := range changes {
+			if value == nil {
+				delete(ret, key)
+			} else {
+				ret[key] = value
+			}
+		}
+		return ret
+	}
+	sign := func(alg, kid string, key interface{}, claims map[string]interface{}) string {
+		token, err := SignJWT(alg, kid, key, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"hs256", sign("HS256", "hs", testJWTSecret, claims(nil)), nil},
+		{"rs256", sign("RS256", "rs", rsaKey, claims(nil)), nil},
+		{"no kid", sign("HS256", "", testJWTSecret, claims(nil)), nil},
+		{"missing", "", errTokenMissing},
+		{"malformed", "a.b", errTokenMalformed},
+		{"alg none", unsignedToken(claims(nil)), errTokenAlg},
+		{"wrong secret", sign("HS256", "hs", []byte("other"), claims(nil)), errTokenSignature},
+		{"unknown kid", sign("HS256", "k9", testJWTSecret, claims(nil)), errTokenSignature},
+		{"hs256 with rsa public key", sign("HS256", "rs", publicDER, claims(nil)), errTokenSignature},
+		{"alg mismatch kid", sign("HS256", "rs", testJWTSecret, claims(nil)), errTokenSignature},
+		{"expired", sign("HS256", "hs", testJWTSecret,
+			claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})), errTokenExpired},
+		{"expired within leeway", sign("HS256", "hs", testJWTSecret,
+			claims(map[string]interface{}{"exp": now.Add(-10 * time.Second).Unix()})), nil},
+		{"no exp", sign("HS256", "hs", testJWTSecret,
+			claims(map[string]interface{}{"exp": nil})), errTokenExpired},
+		{"not yet", sign("HS256", "hs", testJWTSecret,
+			claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})), errTokenNotYet},
+		{"issued in future", sign("HS256", "hs", testJWTSecret,
+			claims(map[string]interface{}{"iat": now.Add(time.Minute).Unix()})), errTokenNotYet},
+		{"wrong issuer", sign("HS256", "hs", testJWTSecret,
+			claims(map[string]interface{}{"iss": "other"})), errTokenIssuer},
+		{"wrong audience", sign("HS256", "hs", testJWTSecret,
+			claims(map[string]interface{}{"aud": "web"})), errTokenAudience},
+		{"audience list", sign("HS256", "hs", testJWTSecret,
+			claims(map[string]interface{}{"aud": []string{"web", "api"}})), nil},
+		{"no audience", sign("HS256", "hs", testJWTSecret,
+			claims(map[string]interface{}{"aud": nil})), errTokenAudience},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := auth.Verify(test.token)
+			if err != test.err {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if principal.ID != "u1" || principal.Type != AUTH_JWT ||
+				!principal.HasRole("admin") || !principal.HasRole("editor") {
+				t.Errorf("principal = %+v", principal)
+			}
+		})
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	auth := &JWTAuth{}
+	auth.AddKey("old", "HS256", []byte("old"))
+	auth.AddKey("new", "HS256", []byte("new"))
+	claims := map[string]interface{}{"sub": "u1", "exp": time.Now().Add(time.Hour).Unix()}
+	token, _ := SignJWT("HS256", "old", []byte("old"), claims)
+	if _, err := auth.Verify(token); err != nil {
+		t.Fatalf("old key: %v", err)
+	}
+	auth.RemoveKey("old")
+	if _, err := auth.Verify(token); err != errTokenSignature {
+		t.Fatalf("removed key: err = %v, want %v", err, errTokenSignature)
+	}
+}
+
+func TestJWTFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		optional bool
+		header   string
+		status   int
+	}{
+		{"valid", false, "Bearer " + testToken(t, "admin"), STATUS_SUCCESS},
+		{"lower case scheme", false, "bearer " + testToken(t, "admin"), STATUS_SUCCESS},
+		{"missing", false, "", STATUS_UNAUTHORIZED},
+		{"basic scheme", false, "Basic dTE6cGFzcw==", STATUS_UNAUTHORIZED},
+		{"optional missing", true, "", STATUS_SUCCESS},
+		{"optional invalid", true, "Bearer x.y.z", STATUS_UNAUTHORIZED},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			auth := testJWTAuth()
+			auth.Optional = test.optional
+			server := testServer(func(server *Server) {
+				server.NewDocRouter(&Doc{Path: "/jwt"}, auth.Filter)
+			})
+			req := testRequest("GET", "/jwt", nil)
+			if test.header != "" {
+				req.Header.Set("Authorization", test.header)
+			}
+			resp := serveTest(server, req)
+			if resp.Status != test.status {
+				t.Errorf("status = %d, want %d", resp.Status, test.status)
+			}
+			challenge := resp.Header().Get("WWW-Authenticate")
+			if (resp.Status == STATUS_UNAUTHORIZED) != strings.HasPrefix(challenge, "Bearer ") {
+				t.Errorf("WWW-Authenticate = %q", challenge)
+			}
+		})
+	}
+}
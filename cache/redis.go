@@ -1,9 +1,12 @@
 package cache
 
+// 依赖github.com/garyburd/redigo v1.6.0及以上版本，Ping和Stats使用了DoWithTimeout和Pool.Stats
+
 import (
 	"time"
 
 	. "github.com/coral/log"
+	"github.com/coral/trace"
 
 	"github.com/garyburd/redigo/redis"
 )
@@ -15,6 +18,7 @@ type CachePool struct {
 
 //_Redis 类型，用于 内部封装redis连接池
 type _Redis struct {
+	name string
 	conn *redis.Pool
 }
 
@@ -37,6 +41,7 @@ func AddRedis(
 	maxActive, maxIdle int) {
 	Info("add redis", name, server)
 	redis := &_Redis{}
+	redis.name = name
 	redis.conn = newPool(server, auth, maxActive, maxIdle)
 	Cache.Pool[name] = redis
 }
@@ -77,10 +82,29 @@ func newPool(server, password string, maxActive, maxIdle int) *redis.Pool {
 func (redis *_Redis) do(cmd string,
 	args ...interface{}) (reply interface{}, err error) {
 
+	span := trace.Start("redis " + cmd)
+	span.Set("redis", redis.name)
+	defer span.End()
 	conn := redis.conn.Get()
 	defer conn.Close()
 
-	return conn.Do(cmd, args...)
+	reply, err = conn.Do(cmd, args...)
+	span.SetError(err)
+	return reply, err
+}
+
+// Ping 方法，在timeout内检查redis的连通性
+func Ping(name string, timeout time.Duration) error {
+	conn := Cache.Pool[name].conn.Get()
+	defer conn.Close()
+	_, err := redis.DoWithTimeout(conn, timeout, "PING")
+	return err
+}
+
+// Stats 方法，返回连接池中的连接数和空闲连接数
+func Stats(name string) (active, idle int) {
+	stats := Cache.Pool[name].conn.Stats()
+	return stats.ActiveCount, stats.IdleCount
 }
 
 // Get 方法
@@ -108,12 +132,58 @@ func Set(name, key string, val interface{}) bool {
 	return val == "OK"
 }
 
-// Expire 方法
+// SetNX 方法，key不存在时设置并指定过期时间(秒)，返回是否设置成功
+func SetNX(name, key string, val interface{}, expire int) bool {
+	val, err := Cache.Pool[name].do("SET", key, val, "EX", expire, "NX")
+	if err != nil {
+		Error("redis setnx error", name, key, err.Error())
+		return false
+	}
+	return val == "OK"
+}
+
+// SetEX 方法，设置值并指定过期时间(秒)
+func SetEX(name, key string, val interface{}, expire int) bool {
+	val, err := Cache.Pool[name].do("SET", key, val, "EX", expire)
+	if err != nil {
+		Error("redis setex error", name, key, err.Error())
+		return false
+	}
+	return val == "OK"
+}
+
+// Del 方法，返回是否删除了key
+func Del(name, key string) bool {
+	val, err := Cache.Pool[name].do("DEL", key)
+	if err != nil {
+		Error("redis del error", name, key, err.Error())
+		return false
+	}
+	return val == int64(1)
+}
+
+// Eval 方法，执行lua脚本，出错时返回nil
+func Eval(name, script string, keys []string, args ...interface{}) interface{} {
+	params := []interface{}{script, len(keys)}
+	for _, key := range keys {
+		params = append(params, key)
+	}
+	params = append(params, args...)
+	val, err := Cache.Pool[name].do("EVAL", params...)
+	if err != nil {
+		Error("redis eval error", name, keys, err.Error())
+		return nil
+	}
+	return val
+}
+
+// Expire 方法，返回key是否存在，存在时设置了过期时间
+// EXPIRE返回的是整数1或0，以前与"OK"比较总是返回false，调用方如果依赖返回值需要注意
 func Expire(name, key string, expire int) bool {
 	val, err := Cache.Pool[name].do("EXPIRE", key, expire)
 	if err != nil {
 		Error("redis set error", name, key, err.Error())
 		return false
 	}
-	return val == "OK"
+	return val == int64(1)
 }
@@ -0,0 +1,123 @@
+package coral
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/coral/config"
+	. "github.com/coral/log"
+)
+
+// SetTrustedProxies 指定可信的代理，如负载均衡的地址，支持ip和CIDR
+// 只有来自可信代理的请求才会使用X-Forwarded-For和X-Real-IP中的客户端ip
+func (server *Server) SetTrustedProxies(cidrs ...string) {
+	server.trustedProxies = parseCIDRs(cidrs)
+}
+
+// ClientIP 返回客户端的真实ip
+func (context *Context) ClientIP() string {
+	return context.clientIP
+}
+
+// clientIP 返回请求的客户端ip
+// 连接来自可信代理时，从右向左取X-Forwarded-For中第一个不可信的ip
+// 全部可信时取最左边的ip，没有X-Forwarded-For时使用X-Real-IP
+func (router *Router) clientIP(req *http.Request) string {
+	remote := remoteIP(req)
+	if router.server == nil || !containsIP(router.server.trustedProxies, remote) {
+		return remote
+	}
+	trusted := router.server.trustedProxies
+	var forwarded []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(ip))
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if net.ParseIP(forwarded[i]) == nil {
+			// 无法解析时不再相信更左边的地址
+			Debug("invalid X-Forwarded-For", forwarded[i])
+			return remote
+		}
+		if i == 0 || !containsIP(trusted, forwarded[i]) {
+			return forwarded[i]
+		}
+	}
+	if ip := strings.TrimSpace(req.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return remote
+}
+
+// parseCIDRs 解析ip和CIDR，忽略空值
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	var ret []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr = cidr + "/32"
+			} else {
+				cidr = cidr + "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			Error("invalid cidr", cidr, err.Error())
+			continue
+		}
+		ret = append(ret, ipNet)
+	}
+	return ret
+}
+
+// containsIP 判断ip是否在nets中
+func containsIP(nets []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range nets {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPAccess 是按客户端ip的访问控制，通过Filter作为路由的filter使用
+type IPAccess struct {
+	Status int // 拒绝访问时返回的status，默认STATUS_FORBIDDEN
+
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewIPAccess 从配置中读取ip的白名单和黑名单，多个值用逗号分隔
+// 先检查黑名单，白名单为空时允许其他所有ip
+// [admin_ip]
+// ALLOW = 10.0.0.0/8,192.168.1.10
+// DENY = 10.0.3.0/24
+func NewIPAccess(conf config.Configer, group string) *IPAccess {
+	return &IPAccess{
+		Status: STATUS_FORBIDDEN,
+		allow:  parseCIDRs(splitConfig(conf.Get(group + ".ALLOW"))),
+		deny:   parseCIDRs(splitConfig(conf.Get(group + ".DENY")))}
+}
+
+// Filter 检查客户端ip是否允许访问
+func (access *IPAccess) Filter(context *Context) bool {
+	ip := context.ClientIP()
+	if containsIP(access.deny, ip) ||
+		(len(access.allow) > 0 && !containsIP(access.allow, ip)) {
+		Debug("ip denied", ip)
+		context.Status = access.Status
+		return false
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package coral
+
+import (
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	server := NewServer("")
+	server.SetTrustedProxies("10.0.0.0/8", "192.0.2.1", " ", "2001:db8::/32", "bad")
+	router := &Router{server: server}
+
+	tests := []struct {
+		name     string
+		remote   string
+		forwards []string // 每个元素是一个X-Forwarded-For头
+		realIP   string
+		ip       string
+	}{
+		{"untrusted remote", "203.0.113.9:1234", []string{"1.1.1.1"}, "2.2.2.2", "203.0.113.9"},
+		{"no header", "192.0.2.1:1234", nil, "", "192.0.2.1"},
+		{"single hop", "192.0.2.1:1234", []string{"1.1.1.1"}, "", "1.1.1.1"},
+		{"spoofed left", "192.0.2.1:1234", []string{"6.6.6.6, 1.1.1.1"}, "", "1.1.1.1"},
+		{"trusted chain", "192.0.2.1:1234", []string{"6.6.6.6, 1.1.1.1, 10.0.0.2, 10.1.0.3"}, "",
+			"1.1.1.1"},
+		{"all trusted", "192.0.2.1:1234", []string{"10.0.0.5, 10.0.0.2"}, "", "10.0.0.5"},
+		{"multiple headers", "192.0.2.1:1234", []string{"6.6.6.6", "1.1.1.1, 10.0.0.2"}, "",
+			"1.1.1.1"},
+		{"invalid stops walk", "192.0.2.1:1234", []string{"1.1.1.1, unknown, 10.0.0.2"}, "",
+			"192.0.2.1"},
+		{"invalid right", "192.0.2.1:1234", []string{"1.1.1.1, 10.0.0.2:80"}, "", "192.0.2.1"},
+		{"ipv6", "[2001:db8::1]:1234", []string{"2001:db9::5, 2001:db8::7"}, "", "2001:db9::5"},
+		{"real ip", "192.0.2.1:1234", nil, " 1.1.1.1 ", "1.1.1.1"},
+		{"invalid real ip", "192.0.2.1:1234", nil, "unknown", "192.0.2.1"},
+		{"forwarded before real ip", "192.0.2.1:1234", []string{"1.1.1.1"}, "2.2.2.2", "1.1.1.1"},
+		{"remote without port", "192.0.2.1", []string{"1.1.1.1"}, "", "1.1.1.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := testRequest("GET", "/", nil)
+			req.RemoteAddr = test.remote
+			for _, forward := range test.forwards {
+				req.Header.Add("X-Forwarded-For", forward)
+			}
+			if test.realIP != "" {
+				req.Header.Set("X-Real-IP", test.realIP)
+			}
+			if ip := router.clientIP(req); ip != test.ip {
+				t.Errorf("clientIP = %q, want %q", ip, test.ip)
+			}
+		})
+	}
+
+	// 没有可信代理时只使用连接地址
+	req := testRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	if ip := (&Router{server: NewServer("")}).clientIP(req); ip != "192.0.2.1" {
+		t.Errorf("clientIP without trusted proxies = %q", ip)
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets := parseCIDRs([]string{"10.0.0.1", "::1", "192.168.0.0/16", "", "bad", "1.2.3.4/33"})
+	if len(nets) != 3 {
+		t.Fatalf("nets = %v", nets)
+	}
+	for ip, contains := range map[string]bool{
+		"10.0.0.1": true, "10.0.0.2": false, "::1": true, "192.168.3.4": true,
+		"172.16.0.1": false, "bad": false} {
+		if containsIP(nets, ip) != contains {
+			t.Errorf("containsIP(%q) = %v", ip, !contains)
+		}
+	}
+}
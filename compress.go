@@ -0,0 +1,282 @@
+package coral
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DefaultCompressSize 是默认开始压缩的响应大小，单位byte
+const DefaultCompressSize = 1024
+
+// Encoder 根据w创建一个压缩writer
+type Encoder func(w io.Writer) io.WriteCloser
+
+type encoderEntry struct {
+	name    string
+	factory Encoder
+}
+
+var (
+	encoderMux = new(sync.RWMutex)
+	encoders   []*encoderEntry // 按优先级排序
+)
+
+// 内置deflate，gzip和br，优先级依次升高
+// http的deflate是zlib格式，不是裸的deflate数据
+func init() {
+	RegisterEncoder("deflate", func(w io.Writer) io.WriteCloser {
+		return zlib.NewWriter(w)
+	})
+	RegisterEncoder("gzip", func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	})
+	RegisterEncoder("br", func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriter(w)
+	})
+}
+
+// RegisterEncoder 注册一种压缩方式，后注册的优先级更高
+// 与已有的压缩方式同名时替换原来的实现
+func RegisterEncoder(name string, factory Encoder) {
+	encoderMux.Lock()
+	defer encoderMux.Unlock()
+	name = strings.ToLower(name)
+	list := []*encoderEntry{{name: name, factory: factory}}
+	for _, entry := range encoders {
+		if entry.name != name {
+			list = append(list, entry)
+		}
+	}
+	encoders = list
+}
+
+// EnableCompression 开启响应压缩，响应超过minSize字节时才会压缩
+// minSize为0时使用DefaultCompressSize
+func (server *Server) EnableCompression(minSize int) {
+	if minSize <= 0 {
+		minSize = DefaultCompressSize
+	}
+	server.compressSize = minSize
+}
+
+// DisableCompression 关闭该路由及其子路由的响应压缩
+func (router *Router) DisableCompression() *Router {
+	router.noCompress = true
+	return router
+}
+
+// negotiateEncoding 根据Accept-Encoding选择q值最高的压缩方式
+// q值相同时按注册的优先级选择，只在已注册的压缩方式中选择
+func negotiateEncoding(header string) (string, Encoder) {
+	if header == "" {
+		return "", nil
+	}
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		q := 1.0
+		params := strings.Split(part, ";")
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if name := strings.ToLower(strings.TrimSpace(params[0])); name != "" {
+			accepted[name] = q
+		}
+	}
+	encoderMux.RLock()
+	defer encoderMux.RUnlock()
+	var best *encoderEntry
+	bestQ := 0.0
+	for _, entry := range encoders {
+		q, ok := accepted[entry.name]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = entry, q
+		}
+	}
+	if best == nil {
+		return "", nil
+	}
+	return best.name, best.factory
+}
+
+// addVary 添加Vary响应头，已经存在时不重复添加
+func addVary(header http.Header, value string) {
+	for _, vary := range header["Vary"] {
+		for _, v := range strings.Split(vary, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
+
+// compressWriter 在响应超过阈值时压缩输出
+// 未达到阈值前数据先缓存，头部也延迟到确定是否压缩后再输出
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	factory  Encoder
+	minSize  int
+
+	buf      []byte
+	status   int
+	decided  bool
+	hijacked bool
+	encoder  io.WriteCloser
+}
+
+// compressWriter 返回压缩输出的writer，不需要压缩时返回nil
+func (router *Router) compressWriter(
+	w http.ResponseWriter, req *http.Request) *compressWriter {
+	if router.server == nil || router.server.compressSize <= 0 || router.noCompress {
+		return nil
+	}
+	// HEAD请求不压缩，但Vary需要与GET一致
+	addVary(w.Header(), "Accept-Encoding")
+	if req.Method == "HEAD" {
+		return nil
+	}
+	encoding, factory := negotiateEncoding(req.Header.Get("Accept-Encoding"))
+	if factory == nil {
+		return nil
+	}
+	return &compressWriter{
+		ResponseWriter: w,
+		encoding:       encoding,
+		factory:        factory,
+		minSize:        router.server.compressSize}
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) >= cw.minSize {
+			if err := cw.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide 确定是否压缩并输出头部和缓存的数据
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	header := cw.Header()
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if compress && cw.compressible() {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", cw.encoding)
+		cw.ResponseWriter.WriteHeader(cw.status)
+		cw.encoder = cw.factory(cw.ResponseWriter)
+		_, err := cw.encoder.Write(cw.buf)
+		cw.buf = nil
+		return err
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	_, err := cw.ResponseWriter.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// compressible 判断响应是否适合压缩
+// 已经压缩过的数据，部分内容和没有body的响应不压缩
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" ||
+		header.Get("Content-Range") != "" ||
+		cw.status < 200 ||
+		cw.status == http.StatusNoContent ||
+		cw.status == http.StatusNotModified ||
+		cw.status == http.StatusPartialContent {
+		return false
+	}
+	ctype := header.Get("Content-Type")
+	if ctype == "" {
+		ctype = http.DetectContentType(cw.buf)
+	}
+	switch {
+	case strings.HasPrefix(ctype, "image/") && !strings.HasPrefix(ctype, "image/svg"),
+		strings.HasPrefix(ctype, "video/"),
+		strings.HasPrefix(ctype, "audio/"),
+		strings.Contains(ctype, "zip"),
+		strings.Contains(ctype, "compressed"):
+		return false
+	}
+	return true
+}
+
+// Flush 用于流式输出，未确定时不再压缩
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if flusher, ok := cw.encoder.(interface {
+		Flush() error
+	}); ok {
+		flusher.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack 用于websocket，接管后不再压缩
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	cw.decided = true
+	cw.hijacked = true
+	return hijacker.Hijack()
+}
+
+// Close 输出未达到阈值的数据，并结束压缩
+func (cw *compressWriter) Close() error {
+	if cw.hijacked {
+		return nil
+	}
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// 没有任何输出，交给http包处理
+			cw.decided = true
+			return nil
+		}
+		return cw.decide(false)
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+	return nil
+}
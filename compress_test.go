@@ -0,0 +1,137 @@
+package coral
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		encoding string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"GZIP", "gzip"},
+		{"deflate", "deflate"},
+		{"br", "br"},
+		{"gzip, deflate, br", "br"},
+		{"gzip, deflate", "gzip"},
+		{"gzip;q=0.5, deflate;q=0.8", "deflate"},
+		{"br;q=0.1, gzip", "gzip"},
+		{"gzip; level=1; q=0.2, deflate;q=0.1", "gzip"},
+		{"gzip;q=0", ""},
+		{"gzip;q=0, deflate", "deflate"},
+		{"identity", ""},
+		{"identity;q=0", ""},
+		{"identity;q=0, gzip", "gzip"},
+		{"*", "br"},
+		{"*;q=0", ""},
+		{"br;q=0, *", "gzip"},
+		{"br;q=0, gzip;q=0, *;q=0.5", "deflate"},
+		{"compress, zstd", ""},
+		{" , gzip ,", "gzip"},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			encoding, factory := negotiateEncoding(test.header)
+			if encoding != test.encoding || (factory == nil) != (test.encoding == "") {
+				t.Errorf("negotiateEncoding(%q) = %q, want %q",
+					test.header, encoding, test.encoding)
+			}
+		})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	large := strings.Repeat("coral compression ", 100)
+	decoders := map[string]func(r io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		"deflate": func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		},
+		"br": func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+	}
+	server := testServer(func(server *Server) {
+		server.EnableCompression(1024)
+		server.NewRouter("/large", func(context *Context) bool {
+			context.Raw = true
+			context.Data = large
+			return true
+		})
+		server.NewRouter("/small", func(context *Context) bool {
+			context.Raw = true
+			context.Data = "small"
+			return true
+		})
+		server.NewRouter("/image", func(context *Context) bool {
+			context.Raw = true
+			context.SetHeader("Content-Type", "image/png")
+			context.Data = large
+			return true
+		})
+		server.NewRouter("/off", func(context *Context) bool {
+			context.Raw = true
+			context.Data = large
+			return true
+		}).DisableCompression()
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		accept   string
+		encoding string
+		body     string
+	}{
+		{"gzip", "GET", "/large", "gzip", "gzip", large},
+		{"deflate", "GET", "/large", "deflate", "deflate", large},
+		{"br", "GET", "/large", "gzip, deflate, br", "br", large},
+		{"not accepted", "GET", "/large", "", "", large},
+		{"below threshold", "GET", "/small", "gzip", "", "small"},
+		{"image", "GET", "/image", "gzip", "", large},
+		{"disabled", "GET", "/off", "gzip", "", large},
+		{"head", "HEAD", "/large", "gzip", "", large},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := testRequest(test.method, test.target, nil)
+			if test.accept != "" {
+				req.Header.Set("Accept-Encoding", test.accept)
+			}
+			resp := serveTest(server, req)
+			encoding := resp.Header().Get("Content-Encoding")
+			if encoding != test.encoding {
+				t.Fatalf("Content-Encoding = %q, want %q", encoding, test.encoding)
+			}
+			var reader io.Reader = resp.Body
+			if encoding != "" {
+				var err error
+				if reader, err = decoders[encoding](resp.Body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			body, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != test.body {
+				t.Errorf("body = %q, want %q", body, test.body)
+			}
+			if test.target != "/off" && !strings.Contains(resp.Header().Get("Vary"), "Accept-Encoding") {
+				t.Errorf("Vary = %q", resp.Header().Get("Vary"))
+			}
+		})
+	}
+}
@@ -107,7 +107,7 @@ func (config *IniConfiger) set(key, value string) {
 		config.data[group] = make(map[string]string)
 	}
 	config.data[group][element] = value
-	Info("set config", group+","+key+"="+value)
+	Info("set config", group+","+key+"="+Mask(key, value))
 }
 
 func (config *IniConfiger) Bool(key string) bool {
@@ -147,6 +147,19 @@ func (config *IniConfiger) String(key string) string {
 	return config.Get(key)
 }
 
+// All 返回所有配置项的副本，key为group.element
+func (config *IniConfiger) All() map[string]string {
+	config.mux.RLock()
+	defer config.mux.RUnlock()
+	ret := make(map[string]string)
+	for group, g := range config.data {
+		for element, value := range g {
+			ret[group+"."+element] = value
+		}
+	}
+	return ret
+}
+
 func escapeKey(key string) (string, string) {
 	groupKey := strings.Split(strings.ToLower(key), ".")
 	var group, element string
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Secrets 是需要隐藏的配置项，配置项名称包含其中任意一个时隐藏，不区分大小写
+var Secrets = []string{"password", "passwd", "pwd", "secret", "token", "auth", "key"}
+
+// dsnPassword 匹配dsn中的密码，如user:password@tcp(...)
+var dsnPassword = regexp.MustCompile(`([^:/@]*):([^@/]+)@`)
+
+// Mask 返回可以输出的配置值，隐藏敏感配置项和dsn中的密码
+// 日志和管理server输出配置时使用
+func Mask(key, value string) string {
+	if value == "" {
+		return value
+	}
+	lower := strings.ToLower(key)
+	for _, secret := range Secrets {
+		if strings.Contains(lower, secret) {
+			return "***"
+		}
+	}
+	return dsnPassword.ReplaceAllString(value, "$1:***@")
+}
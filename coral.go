@@ -2,15 +2,20 @@ package coral
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
+	"net"
 	"net/http"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	. "github.com/coral/log"
+	"github.com/coral/trace"
 )
 
 // Server是一个服务的对象定义，一个server对应一个端口监听
@@ -18,6 +23,31 @@ type Server struct {
 	host    string
 	mux     *http.ServeMux
 	routers []*Router
+
+	httpStatus bool // 是否根据status返回对应的http状态码
+
+	uploadMemory int64 // 上传文件占用内存的上限
+	uploadLimit  int64 // 请求体的最大字节数
+
+	cors         *CORS // 跨域策略
+	compressSize int   // 开始压缩的响应大小，为0时不压缩
+
+	accessLog *AccessLog // 访问日志配置，为nil时使用DefaultAccessLog
+	metrics   *metrics   // 请求统计，为nil时不统计
+
+	permissionResolver PermissionResolver // 检查Doc.Permissions的方法
+	trustedProxies     []*net.IPNet       // 可信的代理
+
+	health        bool           // 是否注册健康检查的路由
+	healthChecks  []*healthCheck // 自定义健康检查
+	healthTimeout time.Duration  // 每个健康检查的超时时间
+
+	httpServer      *http.Server
+	webSockets      *webSocketHub // 所有websocket连接，停止时关闭
+	stopped         chan struct{} // 优雅停止完成时关闭
+	shuttingDown    int32         // 为1时正在停止，就绪检查返回失败
+	shutdownDelay   time.Duration // 停止接收新请求前的等待时间
+	shutdownTimeout time.Duration // 等待处理中请求结束的最长时间
 }
 
 // Router 是一个路由数据结构定义
@@ -26,11 +56,18 @@ type Router struct {
 	path    string
 	docPath string
 	routers []*Router
+	server  *Server
 
 	doc *Doc
 
 	handler    func(http.ResponseWriter, *http.Request)
 	docHandler func(http.ResponseWriter, *http.Request)
+
+	heartbeat time.Duration // 流式接口和websocket的心跳间隔
+	hub       *webSocketHub // websocket连接
+	cors      *CORS         // 跨域策略，为空时使用server的策略
+
+	noCompress bool // 是否关闭响应压缩
 }
 
 // Doc 用于生成api doc
@@ -39,8 +76,15 @@ type Doc struct {
 	Path        string
 	Description string
 	docPath     string
+	kind        string // 接口类型，为空时是普通的json接口
 	Input       Checker
 	Output      Checker
+	Message     Checker // websocket接口的消息校验规则
+	Auth        string  // 认证要求，如"Bearer JWT"，只用于生成doc
+
+	// 授权要求，调用方通过认证后由系统检查
+	Roles       []string // 需要其中任意一个角色
+	Permissions []string // 需要全部权限，通过Server.SetPermissionResolver判断
 }
 
 type Checker map[string]interface{}
@@ -55,17 +99,31 @@ type Filter func(context *Context) bool
 // 暴露给所有filter方法
 // 请求的接收与返回也都通过其记录处理
 type Context struct {
-	req *http.Request
-	w   http.ResponseWriter
+	req       *http.Request
+	w         *responseWriter
+	startTime time.Time
 
-	Host   string
-	Path   string
-	Params map[string]interface{}
-	Data   interface{}
-	Status int
-	Errmsg string
+	Host      string
+	Path      string
+	RequestID string // 请求id，取自X-Request-ID或者由系统生成
+	Params    map[string]interface{}
+	Data      interface{}
+	Status    int
+	Errmsg    string
 
+	// Raw 为true时不做json包装，直接输出Data
+	// Data可以是string，[]byte或者io.Reader
 	Raw bool
+
+	httpStatus int        // 指定的http状态码，为0时由系统决定
+	redirect   string     // 重定向地址
+	file       *fileBody  // 需要输出的文件
+	principal  *Principal // 通过认证的调用方
+	session    *Session   // 使用SessionManager时的session
+	csrf       *CSRF      // 使用的CSRF，用于延迟生成token
+	csrfToken  string     // 使用CSRF时的token
+	clientIP   string     // 客户端的真实ip
+	tooLarge   bool       // 请求体超过SetUpload指定的limit
 }
 
 // Response 是请求返回数据类型
@@ -236,6 +294,7 @@ func NewServer(host string) *Server {
 	server := &Server{}
 	server.mux = http.NewServeMux()
 	server.host = host
+	server.webSockets = newWebSocketHub()
 	return server
 }
 
@@ -244,16 +303,26 @@ func (server *Server) AddRoute(router *Router) {
 	server.routers = append(server.routers, router)
 }
 
+// EnableHTTPStatus 开启后，json响应的http状态码将根据status映射得到
+// 映射关系参考MapStatus
+func (server *Server) EnableHTTPStatus() {
+	server.httpStatus = true
+}
+
 // Run启动server的服务
 func (server *Server) Run() {
+	checkStatus()
 	server.registerRouters()
+	server.registerHealth()
 	Info("coral listening on", server.host)
 	Info("========================================")
-	err := http.ListenAndServe(server.host, server.mux)
+	err := server.serve()
 	if err != nil {
 		Error(err)
 		Error("server start FAILD!")
 	}
+	// 等待异步日志写入文件
+	Flush()
 }
 
 // 创建一个新的路由对象并返回引用
@@ -289,21 +358,62 @@ func (server *Server) registerRouters() {
 // registerRouter 递归注册指定的一个router
 func (server *Server) registerRouter(router *Router) {
 	Info("register router", router.path)
-	server.mux.HandleFunc(router.path, router.handler)
+	router.server = server
+	server.mux.HandleFunc(router.path, router.serveHTTP)
 	for _, child := range router.routers {
+		if child.cors == nil {
+			child.cors = router.cors
+		}
+		if router.noCompress {
+			child.noCompress = true
+		}
 		server.registerRouter(child)
 	}
 }
 
 // registerDocRouter 递归注册指定router的doc
 func (server *Server) registerDocRouter(router *Router) {
-	Info("register router doc", router.docPath)
-	server.mux.HandleFunc(router.docPath, router.docHandler)
+	if router.docHandler != nil {
+		Info("register router doc", router.docPath)
+		server.mux.HandleFunc(router.docPath, router.docHandler)
+	}
 	for _, child := range router.routers {
 		server.registerDocRouter(child)
 	}
 }
 
+// serveHTTP 是路由注册的入口，处理所有类型路由共用的逻辑
+func (router *Router) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	// 请求id写回请求头，后续处理统一从请求头中获取
+	// 同时绑定为日志标记，处理过程中输出的日志都会带上请求id
+	requestID := genRequestID(req)
+	req.Header.Set("X-Request-ID", requestID)
+	w.Header().Set("X-Request-ID", requestID)
+	Bind(requestID)
+	defer Unbind()
+
+	span := trace.StartRemote(
+		req.Method+" "+router.path, req.Header.Get("traceparent"))
+	span.Set("http.method", req.Method)
+	span.Set("http.path", req.URL.Path)
+	span.Set("request_id", requestID)
+	defer span.End()
+
+	if m := router.metrics(); m != nil {
+		atomic.AddInt64(&m.inFlight, 1)
+		defer atomic.AddInt64(&m.inFlight, -1)
+	}
+
+	if cors := router.corsPolicy(); cors != nil && cors.handle(w, req) {
+		return
+	}
+	if cw := router.compressWriter(w, req); cw != nil {
+		defer cw.Close()
+		w = cw
+	}
+	router.handler(w, req)
+}
+
 // 添加一个子路由
 func (router *Router) NewRouter(path string, filterChains ...Filter) *Router {
 	// path head must be "/"
@@ -338,103 +448,210 @@ func (router *Router) NewDocRouter(doc *Doc, filterChains ...Filter) *Router {
 // 在所有filter执行结束后，返回了context中的response数据
 func (router *Router) genHandler(filterChains ...Filter) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
-		startTime := time.Now()
-		context := &Context{}
-		context.req = req
-		context.w = w
-		context.Host = req.Host
-		context.Path = router.path
-		// deal params
-		context.Params = router.processParams(req)
+		context := router.newContext(w, req)
+		ret, response := router.process(context, filterChains...)
+		router.respond(context, ret, response)
+	}
+}
 
-		ret := true
-		response := &Response{}
+// newContext 创建请求上下文并处理参数
+func (router *Router) newContext(w http.ResponseWriter, req *http.Request) *Context {
+	context := &Context{}
+	context.startTime = time.Now()
+	context.req = req
+	context.w = &responseWriter{ResponseWriter: w}
+	context.Host = req.Host
+	context.clientIP = router.clientIP(req)
+	context.Path = router.path
+	context.RequestID = req.Header.Get("X-Request-ID")
+	if router.server != nil && router.server.uploadLimit > 0 {
+		req.Body = http.MaxBytesReader(w, req.Body, router.server.uploadLimit)
+	}
+	// deal params
+	context.Params, context.tooLarge = router.processParams(req)
+	return context
+}
 
-		// param check if need
-		if router.doc.Input != nil {
-			ret, context.Status = router.doc.Input.check(context.Params)
-			if !ret {
-				Debug("input check faild")
-			}
-		}
+// process 检查参数并顺序执行filterChains
+// 返回是否全部通过，以及filter中断时的默认response
+func (router *Router) process(
+	context *Context, filterChains ...Filter) (bool, *Response) {
+	ret := true
+	response := &Response{}
 
-		if ret {
-			for _, filter := range filterChains {
-				ret = filter(context)
-				if !ret {
-					Debug("filter break", filter)
-					if context.Status == 0 {
-						response.Status = STATUS_ERROR_UNKNOWN
-					}
-					if context.Errmsg == "" {
-						response.Errmsg = "filter return false"
-					}
-					break
-				}
-			}
+	// 请求体超过上限时参数不完整，不再校验和执行filter
+	if context.tooLarge {
+		context.Status = STATUS_INVALID_PARAM
+		context.httpStatus = http.StatusRequestEntityTooLarge
+		return false, response
+	}
+
+	// param check if need
+	if router.doc.Input != nil {
+		ret, context.Status = router.doc.Input.check(context.Params)
+		if !ret {
+			Debug("input check faild")
 		}
+	}
 
-		if context.Raw {
-			Info(
-				"<-",
-				time.Now().Sub(startTime),
-				context.Host,
-				context.Path,
-				context.Params,
-				"->",
-				context.Data)
-			w.Write([]byte(context.Data.(string)))
-		} else {
-			if context.Status != 0 {
-				response.Status = context.Status
-			}
-			if context.Data != nil {
-				response.Data = context.Data
-			} else {
-				response.Data = make(map[string]interface{})
-			}
-			if context.Errmsg != "" {
-				response.Errmsg = context.Errmsg
+	if ret {
+		// doc声明了角色或权限时，每个filter之后检查，设置了Principal就立即授权
+		// 最后一个认证filter之后仍未认证时，不再执行后面的filter，返回STATUS_UNAUTHORIZED
+		authorized := !router.doc.requiresAuth()
+		lastAuth := -1
+		if !authorized {
+			lastAuth = lastAuthFilter(filterChains)
+		}
+		for i, filter := range filterChains {
+			if !authorized && i > lastAuth {
+				Debug("authentication required", router.path)
+				context.Status = STATUS_UNAUTHORIZED
+				ret = false
+				break
 			}
-			// check response
-			if ret && router.doc.Output != nil {
-				resp := map[string]interface{}{
-					"status": response.Status,
-					"data":   response.Data,
-					"errmsg": response.Errmsg}
-				ret, context.Status = router.doc.Output.check(resp)
-				if !ret {
-					Debug("output check faild")
+			ret = router.runFilter(context, filter)
+			if !ret {
+				Debug("filter break", filter)
+				if context.Status == 0 {
+					response.Status = STATUS_ERROR_UNKNOWN
+				}
+				if context.Errmsg == "" {
+					response.Errmsg = "filter return false"
 				}
+				break
 			}
-			if context.Status != 0 {
-				response.Status = context.Status
+			if !authorized && context.principal != nil {
+				if !router.authorize(context.principal) {
+					context.Status = STATUS_FORBIDDEN
+					ret = false
+					break
+				}
+				authorized = true
 			}
+		}
+		// 没有filter或者认证filter没有设置Principal时，不交给stream和websocket的处理方法
+		if ret && !authorized {
+			Debug("authentication required", router.path)
+			context.Status = STATUS_UNAUTHORIZED
+			ret = false
+		}
+	}
+	// 所有filter执行结束后保存session，此时还没有输出响应头
+	if context.session != nil {
+		context.session.manager.save(context)
+	}
+	return ret, response
+}
 
-			out, err := json.Marshal(response)
-			if err != nil {
-				Error(err)
-			}
-			Info(
-				"<-",
-				time.Now().Sub(startTime),
-				context.Host,
-				context.Path,
-				context.Params,
-				"->",
-				context.Status,
-				context.Data,
-				context.Errmsg)
-			w.Write(out)
+// runFilter 执行一个filter，开启统计或追踪时记录filter的耗时
+func (router *Router) runFilter(context *Context, filter Filter) bool {
+	m := router.metrics()
+	if m == nil && !trace.Enabled() {
+		return filter(context)
+	}
+	name := filterName(filter)
+	span := trace.Start("filter " + name)
+	// filter panic时也要结束span，否则span会留在goroutine上
+	defer span.End()
+	start := time.Now()
+	ret := filter(context)
+	if m != nil {
+		m.observeFilter(router.path, name, time.Now().Sub(start))
+	}
+	if !ret {
+		span.Set("break", true)
+	}
+	return ret
+}
+
+// respond 输出context中的数据并记录访问日志
+func (router *Router) respond(context *Context, ret bool, response *Response) {
+	rw := context.w
+	if context.Raw {
+		context.writeRaw()
+		router.finish(context)
+		return
+	}
+	if context.Status != 0 {
+		response.Status = context.Status
+	}
+	if context.Data != nil {
+		response.Data = context.Data
+	} else {
+		response.Data = make(map[string]interface{})
+	}
+	if context.Errmsg != "" {
+		response.Errmsg = context.Errmsg
+	}
+	// check response
+	if ret && router.doc.Output != nil {
+		resp := map[string]interface{}{
+			"status": response.Status,
+			"data":   response.Data,
+			"errmsg": response.Errmsg}
+		ret, context.Status = router.doc.Output.check(resp)
+		if !ret {
+			Debug("output check faild")
+		}
+	}
+	if context.Status != 0 {
+		response.Status = context.Status
+	}
+	if context.Errmsg == "" {
+		message := StatusMessage(
+			response.Status, context.req.Header.Get("Accept-Language"))
+		if message != "" {
+			response.Errmsg = message
+		}
+	}
+	if router.server != nil && router.server.httpStatus &&
+		context.httpStatus == 0 {
+		context.httpStatus = HTTPStatus(response.Status)
+	}
+
+	out, err := json.Marshal(response)
+	if err != nil {
+		Error(err)
+	}
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if context.httpStatus != 0 {
+		rw.WriteHeader(context.httpStatus)
+	}
+	rw.Write(out)
+	context.Status, context.Errmsg = response.Status, response.Errmsg
+	router.finish(context)
+}
+
+// finish 在请求结束时记录访问日志，统计和追踪
+func (router *Router) finish(context *Context) {
+	router.accessLog(context)
+	if span := trace.Current(); span != nil {
+		span.Set("http.status", context.w.status)
+		if !context.Raw {
+			span.Set("status", context.Status)
 		}
 	}
+	if m := router.metrics(); m != nil {
+		m.observeRequest(context)
+	}
 }
 
 // 处理参数，从请求中提取所有参数
-func (router *Router) processParams(req *http.Request) map[string]interface{} {
-	err := req.ParseForm()
+// 请求体超过SetUpload指定的limit时返回true
+func (router *Router) processParams(req *http.Request) (map[string]interface{}, bool) {
+	var err error
+	if strings.HasPrefix(
+		req.Header.Get("Content-Type"), "multipart/form-data") {
+		// ParseMultipartForm同时会解析普通参数
+		err = router.parseMultipart(req)
+	} else {
+		err = req.ParseForm()
+	}
+	tooLarge := false
 	if err != nil {
-		Error(err)
+		var maxBytesErr *http.MaxBytesError
+		tooLarge = errors.As(err, &maxBytesErr)
+		Error("parse params error", router.path, err.Error())
 	}
 	params := make(map[string]interface{})
 	for k, vs := range req.Form {
@@ -449,7 +666,10 @@ func (router *Router) processParams(req *http.Request) map[string]interface{} {
 			params[k] = ""
 		}
 	}
-	return params
+	if req.MultipartForm != nil {
+		processUploads(req.MultipartForm, params)
+	}
+	return params, tooLarge
 }
 
 // 创建一个路由
@@ -513,11 +733,15 @@ int(n)			整数n
 int[m,n]		不小于m不大于n的整数
 int{a,b,c}		a,b,c其中一个整数
 datetime		YYYY-mm-dd HH:ii:ss
+file			上传的文件
+file[m,n]		大小不小于m不大于n字节的文件
+file{a,b,c}		类型是a,b,c其中一个的文件，如image/*
 mobile
 md5
 ` +
 			"</pre>" +
 			ret +
+			genStatusView() +
 			"<hr><p>@general by coral</p>"
 		w.Write([]byte(ret))
 	}
@@ -541,15 +765,35 @@ func (doc *Doc) genView() string {
 	if doc == nil {
 		return ""
 	}
-	ret := "<hr>" +
-		"<p>" +
-		"<a href='" + doc.docPath +
-		"' title='click to see sub tree'>@path:</a> " + doc.Path +
-		"</p>"
+	ret := "<hr><p>@path: " + doc.Path + "</p>"
+	if doc.docPath != "" {
+		ret = "<hr>" +
+			"<p>" +
+			"<a href='" + doc.docPath +
+			"' title='click to see sub tree'>@path:</a> " + doc.Path +
+			"</p>"
+	}
+	if doc.kind != "" {
+		ret = ret + "<p>@type: " + doc.kind + "</p>"
+	}
+	if doc.Auth != "" {
+		ret = ret + "<p>@auth: " + html.EscapeString(doc.Auth) + "</p>"
+	}
+	if len(doc.Roles) > 0 {
+		ret = ret + "<p>@roles: " +
+			html.EscapeString(strings.Join(doc.Roles, " | ")) + "</p>"
+	}
+	if len(doc.Permissions) > 0 {
+		ret = ret + "<p>@permissions: " +
+			html.EscapeString(strings.Join(doc.Permissions, ", ")) + "</p>"
+	}
 	if doc.Description != "" {
 		ret = ret + "<p>" + doc.Description + "</p>"
 	}
-	if doc.Input != nil {
+	if doc.Input != nil && doc.Input.hasFile() {
+		ret = ret + "<p>:- input (multipart/form-data)</p>"
+		ret = ret + "<pre>{\n" + doc.Input.genView("\t") + "}</pre>"
+	} else if doc.Input != nil {
 		ret = ret + "<p>:- input</p>"
 		ret = ret + "<pre>{\n" + doc.Input.genView("\t") + "}</pre>"
 	}
@@ -557,6 +801,10 @@ func (doc *Doc) genView() string {
 		ret = ret + "<p>:- output</p>"
 		ret = ret + "<pre>{\n" + doc.Output.genView("\t") + "}</pre>"
 	}
+	if doc.Message != nil {
+		ret = ret + "<p>:- message</p>"
+		ret = ret + "<pre>{\n" + doc.Message.genView("\t") + "}</pre>"
+	}
 	return ret
 }
 
@@ -794,6 +1042,16 @@ func checkSingleRule(param interface{}, singleRule string) (bool, int) {
 				"unexpect int type", param, fmt.Sprintf("%T", param))
 		}
 		break
+	case len(singleRule) >= 4 && singleRule[0:4] == "file":
+		switch param := param.(type) {
+		case *Upload:
+			if checkFile(singleRule, param) {
+				return true, STATUS_SUCCESS
+			}
+		default:
+			Debug("check rule faild",
+				singleRule, param, "file must be upload")
+		}
 	case singleRule == "mobile":
 		switch param := param.(type) {
 		case string:
@@ -873,7 +1131,7 @@ func checkPoint(rule string, point int) bool {
 	return true
 }
 func checkRange(rule string, point int) bool {
-	if len(rule) > 5 && rule[0] == '[' && rule[len(rule)-1] == ']' {
+	if len(rule) > 2 && rule[0] == '[' && rule[len(rule)-1] == ']' {
 		rule = rule[1 : len(rule)-1]
 		tmparr := strings.Split(rule, ",")
 		if len(tmparr) != 2 {
@@ -895,7 +1153,7 @@ func checkRange(rule string, point int) bool {
 			ret = point >= min
 		}
 		if max >= 0 {
-			ret = point <= max
+			ret = ret && point <= max
 		}
 		if !ret {
 			Debug("check range faild", rule, point)
@@ -0,0 +1,56 @@
+package coral
+
+import "testing"
+
+func TestCheckRange(t *testing.T) {
+	tests := []struct {
+		rule  string
+		point int
+		ret   bool
+	}{
+		{"[1,10]", 0, false},
+		{"[1,10]", 1, true},
+		{"[1,10]", 10, true},
+		{"[1,10]", 11, false},
+		{"[1,9]", 0, false},
+		{"[1,9]", 10, false},
+		{"[0,0]", 0, true},
+		{"[0,0]", 1, false},
+		{"[1,-1]", 1000, true},
+		{"[1,-1]", 0, false},
+		{"[-1,5]", -100, true},
+		{"[-1,5]", 6, false},
+		{"[1]", 1, false},
+		{"[a,5]", 1, false},
+		{"[1,b]", 1, false},
+		{"[]", 1, true},
+		{"1,5", 100, true},
+	}
+	for _, test := range tests {
+		if ret := checkRange(test.rule, test.point); ret != test.ret {
+			t.Errorf("checkRange(%q, %d) = %v, want %v",
+				test.rule, test.point, ret, test.ret)
+		}
+	}
+}
+
+func TestRangeRules(t *testing.T) {
+	tests := []struct {
+		param interface{}
+		rule  string
+		ret   bool
+	}{
+		{"0", "int[1,10]", false},
+		{"5", "int[1,10]", true},
+		{"ab", "string[3,5]", false},
+		{"abcd", "string[3,5]", true},
+		{"abcdef", "string[3,5]", false},
+		{nil, "optional|int[1,10]", true},
+	}
+	for _, test := range tests {
+		if ret, _ := checkRule(test.param, test.rule); ret != test.ret {
+			t.Errorf("checkRule(%v, %q) = %v, want %v",
+				test.param, test.rule, ret, test.ret)
+		}
+	}
+}
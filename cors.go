@@ -0,0 +1,200 @@
+package coral
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/coral/config"
+	. "github.com/coral/log"
+)
+
+// CORS 是跨域访问的策略
+type CORS struct {
+	Origins       []string // 允许的Origin，*表示任意，支持https://*.example.com
+	Methods       []string // 允许的方法，为空时允许GET,POST,HEAD
+	Headers       []string // 允许的请求头，为空时允许预检请求中的所有请求头
+	ExposeHeaders []string // 允许客户端读取的响应头
+	Credentials   bool     // 是否允许携带cookie，开启时*不生效，需要明确指定Origin
+	MaxAge        int      // 预检结果的缓存时间，单位秒
+}
+
+// NewCORS 从配置中读取跨域策略，多个值用逗号分隔
+// [cors]
+// ORIGINS = https://a.example.com,https://*.b.example.com
+// METHODS = GET,POST
+// HEADERS = Content-Type,X-Token
+// EXPOSE_HEADERS = X-Request-ID
+// CREDENTIALS = on
+// MAX_AGE = 600
+func NewCORS(conf config.Configer, group string) *CORS {
+	cors := &CORS{
+		Origins:       splitConfig(conf.Get(group + ".ORIGINS")),
+		Methods:       splitConfig(conf.Get(group + ".METHODS")),
+		Headers:       splitConfig(conf.Get(group + ".HEADERS")),
+		ExposeHeaders: splitConfig(conf.Get(group + ".EXPOSE_HEADERS")),
+		Credentials:   conf.Bool(group + ".CREDENTIALS"),
+		MaxAge:        conf.Int(group + ".MAX_AGE")}
+	cors.check()
+	return cors
+}
+
+// SetCORS 指定server上所有路由的跨域策略
+func (server *Server) SetCORS(cors *CORS) {
+	cors.check()
+	server.cors = cors
+}
+
+// SetCORS 指定路由及其子路由的跨域策略，优先于server的策略
+func (router *Router) SetCORS(cors *CORS) *Router {
+	cors.check()
+	router.cors = cors
+	return router
+}
+
+// check 检查允许携带cookie时是否使用了*
+// 任意站点都可以带着cookie访问，所以此时*不生效，只允许明确指定的Origin
+func (cors *CORS) check() {
+	if cors != nil && cors.Credentials && cors.allowAny() {
+		Error("cors origin * is ignored when credentials is on, specify origins explicitly")
+	}
+}
+
+// corsPolicy 返回路由生效的跨域策略
+func (router *Router) corsPolicy() *CORS {
+	if router.cors != nil {
+		return router.cors
+	}
+	if router.server != nil {
+		return router.server.cors
+	}
+	return nil
+}
+
+// handle 设置跨域响应头，返回true表示预检请求已经处理完毕
+// 预检请求不会执行参数校验和filter
+func (cors *CORS) handle(w http.ResponseWriter, req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	header := w.Header()
+	addVary(header, "Origin")
+	preflight := req.Method == "OPTIONS" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+	if !cors.allowOrigin(origin, !cors.Credentials) {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return preflight
+	}
+
+	if cors.Credentials || !cors.allowAny() {
+		// 允许携带cookie时只会匹配明确指定的Origin
+		header.Set("Access-Control-Allow-Origin", origin)
+	} else {
+		header.Set("Access-Control-Allow-Origin", "*")
+	}
+	if cors.Credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if len(cors.ExposeHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers",
+				strings.Join(cors.ExposeHeaders, ", "))
+		}
+		return false
+	}
+
+	addVary(header, "Access-Control-Request-Method")
+	addVary(header, "Access-Control-Request-Headers")
+	method := req.Header.Get("Access-Control-Request-Method")
+	methods := cors.Methods
+	if len(methods) < 1 {
+		methods = []string{"GET", "POST", "HEAD"}
+	}
+	if !containsFold(methods, method) {
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+	header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(cors.Headers) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(cors.Headers, ", "))
+	} else if reqHeaders := req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", reqHeaders)
+	}
+	if cors.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+func (cors *CORS) allowAny() bool {
+	for _, allowed := range cors.Origins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin 判断origin是否允许，any为false时不接受*
+func (cors *CORS) allowOrigin(origin string, any bool) bool {
+	for _, allowed := range cors.Origins {
+		switch {
+		case allowed == "*":
+			if any {
+				return true
+			}
+		case strings.EqualFold(allowed, origin):
+			return true
+		case strings.Contains(allowed, "*."):
+			if matchWildcardOrigin(allowed, origin) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchWildcardOrigin 判断origin是否匹配https://*.example.com形式的规则
+// 规则省略scheme时只匹配https，规则没有端口时只匹配默认端口
+func matchWildcardOrigin(allowed, origin string) bool {
+	scheme := "https"
+	if i := strings.Index(allowed, "://"); i >= 0 {
+		scheme, allowed = allowed[:i], allowed[i+3:]
+	}
+	if !strings.HasPrefix(allowed, "*.") {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.User != nil || u.Path != "" || u.RawQuery != "" ||
+		!strings.EqualFold(u.Scheme, scheme) {
+		return false
+	}
+	host, suffix := strings.ToLower(u.Host), strings.ToLower(allowed[1:])
+	return len(host) > len(suffix) && strings.HasSuffix(host, suffix)
+}
+
+func containsFold(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitConfig 将逗号分隔的配置拆分为数组，忽略空值
+func splitConfig(value string) []string {
+	var ret []string
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,101 @@
+package coral
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAllowOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		any     bool
+		allow   bool
+	}{
+		{"exact", []string{"https://a.example.com"}, "https://a.example.com", false, true},
+		{"exact case", []string{"https://A.example.com"}, "https://a.example.com", false, true},
+		{"exact other scheme", []string{"https://a.example.com"}, "http://a.example.com", false, false},
+		{"any", []string{"*"}, "https://evil.com", true, true},
+		{"any not accepted", []string{"*"}, "https://evil.com", false, false},
+		{"subdomain", []string{"*.example.com"}, "https://a.example.com", false, true},
+		{"nested subdomain", []string{"*.example.com"}, "https://a.b.example.com", false, true},
+		{"subdomain http", []string{"*.example.com"}, "http://evil.example.com", false, false},
+		{"subdomain with scheme", []string{"https://*.example.com"}, "https://a.example.com", false, true},
+		{"subdomain scheme mismatch", []string{"https://*.example.com"}, "http://a.example.com", false, false},
+		{"http subdomain", []string{"http://*.example.com"}, "http://a.example.com", false, true},
+		{"http subdomain https origin", []string{"http://*.example.com"}, "https://a.example.com", false, false},
+		{"subdomain port", []string{"https://*.example.com"}, "https://a.example.com:8443", false, false},
+		{"subdomain with port", []string{"https://*.example.com:8443"}, "https://a.example.com:8443", false, true},
+		{"subdomain other port", []string{"https://*.example.com:8443"}, "https://a.example.com:9443", false, false},
+		{"bare domain", []string{"*.example.com"}, "https://example.com", false, false},
+		{"suffix attack", []string{"*.example.com"}, "https://evilexample.com", false, false},
+		{"suffix in path", []string{"*.example.com"}, "https://evil.com/a.example.com", false, false},
+		{"userinfo", []string{"*.example.com"}, "https://a.example.com@evil.com", false, false},
+		{"second entry", []string{"https://a.com", "*.example.com"}, "https://b.example.com", false, true},
+		{"null", []string{"*.example.com"}, "null", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cors := &CORS{Origins: test.origins}
+			if allow := cors.allowOrigin(test.origin, test.any); allow != test.allow {
+				t.Errorf("allowOrigin(%q) = %v, want %v", test.origin, allow, test.allow)
+			}
+		})
+	}
+}
+
+func TestCORSHandle(t *testing.T) {
+	tests := []struct {
+		name        string
+		cors        *CORS
+		method      string
+		origin      string
+		reqMethod   string
+		code        int
+		allowOrigin string
+		credentials string
+	}{
+		{"simple", &CORS{Origins: []string{"*"}},
+			"GET", "https://a.com", "", http.StatusOK, "*", ""},
+		{"simple explicit", &CORS{Origins: []string{"https://a.com"}},
+			"GET", "https://a.com", "", http.StatusOK, "https://a.com", ""},
+		{"simple denied", &CORS{Origins: []string{"https://a.com"}},
+			"GET", "https://b.com", "", http.StatusOK, "", ""},
+		{"credentials ignore any", &CORS{Origins: []string{"*"}, Credentials: true},
+			"GET", "https://a.com", "", http.StatusOK, "", ""},
+		{"credentials", &CORS{Origins: []string{"https://*.a.com"}, Credentials: true},
+			"GET", "https://x.a.com", "", http.StatusOK, "https://x.a.com", "true"},
+		{"preflight", &CORS{Origins: []string{"*"}},
+			"OPTIONS", "https://a.com", "POST", http.StatusNoContent, "*", ""},
+		{"preflight method denied", &CORS{Origins: []string{"*"}},
+			"OPTIONS", "https://a.com", "DELETE", http.StatusForbidden, "*", ""},
+		{"preflight origin denied", &CORS{Origins: []string{"https://*.a.com"}},
+			"OPTIONS", "http://x.a.com", "GET", http.StatusForbidden, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := testServer(func(server *Server) {
+				server.SetCORS(test.cors)
+				server.NewRouter("/cors", func(context *Context) bool {
+					return true
+				})
+			})
+			req := testRequest(test.method, "/cors", nil)
+			req.Header.Set("Origin", test.origin)
+			if test.reqMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", test.reqMethod)
+			}
+			resp := serveTest(server, req)
+			if resp.Code != test.code {
+				t.Errorf("code = %d, want %d", resp.Code, test.code)
+			}
+			if origin := resp.Header().Get("Access-Control-Allow-Origin"); origin != test.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", origin, test.allowOrigin)
+			}
+			if credentials := resp.Header().Get("Access-Control-Allow-Credentials"); credentials != test.credentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", credentials, test.credentials)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package coral
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	. "github.com/coral/log"
+)
+
+// csrf token的保存方式
+const (
+	CSRF_DOUBLE_SUBMIT = iota // token保存在cookie中，请求时同时提交cookie和token
+	CSRF_SESSION              // token保存在session中，需要先使用SessionManager.Filter
+)
+
+// csrfSessionKey 是session模式下token在session中的key
+const csrfSessionKey = "coral_csrf"
+
+// CSRF 是跨站请求伪造防护，通过Filter作为路由的filter使用
+// GET，HEAD，OPTIONS和TRACE请求不检查，只下发token
+// 其他请求需要通过请求头或参数提交token
+type CSRF struct {
+	Mode       int
+	CookieName string        // double submit模式保存token的cookie
+	Domain     string        // cookie的domain
+	Path       string        // cookie的path
+	Secure     bool          // 只通过https发送cookie
+	SameSite   http.SameSite // 跨站请求时是否发送cookie
+	Header     string        // 提交token的请求头
+	Param      string        // 提交token的参数，用于普通表单
+	Exempt     []string      // 不检查的认证方式，默认api key和签名认证
+	Status     int           // 检查失败时返回的status，默认STATUS_INVALID_CSRF
+}
+
+// NewCSRF 创建csrf防护，mode为CSRF_DOUBLE_SUBMIT或CSRF_SESSION
+func NewCSRF(mode int) *CSRF {
+	return &CSRF{
+		Mode:       mode,
+		CookieName: "coral_csrf",
+		Path:       "/",
+		Secure:     true,
+		SameSite:   http.SameSiteLaxMode,
+		Header:     "X-CSRF-Token",
+		Param:      "_csrf",
+		Exempt:     []string{AUTH_API_KEY, AUTH_SIGNATURE},
+		Status:     STATUS_INVALID_CSRF}
+}
+
+// CSRFToken 返回当前请求的csrf token，用于输出到页面的表单中
+// session模式下第一次调用时才生成token并保存到session，匿名访问不会因此创建session
+// 需要在filter中调用，路由没有使用CSRF.Filter时返回空字符串
+func (context *Context) CSRFToken() string {
+	if context.csrfToken == "" && context.csrf != nil {
+		context.csrfToken = context.csrf.issue(context)
+	}
+	return context.csrfToken
+}
+
+// Filter 检查非安全方法的请求提交的token
+// double submit模式在安全方法的请求中下发token，session模式在调用CSRFToken时才生成
+// api key等非浏览器的认证方式不检查，需要放在认证filter之后
+func (csrf *CSRF) Filter(context *Context) bool {
+	if csrf.Mode == CSRF_SESSION && context.session == nil {
+		Error("csrf session mode requires session filter", context.Path)
+		context.Status = csrf.Status
+		return false
+	}
+	context.csrf = csrf
+	token := csrf.current(context)
+	context.csrfToken = token
+	switch context.req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		if token == "" && csrf.Mode == CSRF_DOUBLE_SUBMIT {
+			// js需要从cookie中读取token，所以直接下发
+			context.csrfToken = csrf.issue(context)
+		}
+		return true
+	}
+	if principal := context.principal; principal != nil {
+		for _, exempt := range csrf.Exempt {
+			if principal.Type == exempt {
+				return true
+			}
+		}
+	}
+	submitted := context.req.Header.Get(csrf.Header)
+	if submitted == "" && csrf.Param != "" {
+		submitted, _ = context.Params[csrf.Param].(string)
+	}
+	if token == "" || submitted == "" || subtle.ConstantTimeCompare(
+		[]byte(submitted), []byte(token)) != 1 {
+		Debug("csrf token mismatch", context.Path)
+		context.Status = csrf.Status
+		return false
+	}
+	return true
+}
+
+// current 返回已经下发的token，没有时返回空字符串
+func (csrf *CSRF) current(context *Context) string {
+	if csrf.Mode == CSRF_SESSION {
+		token, _ := context.session.Get(csrfSessionKey).(string)
+		return token
+	}
+	return context.Cookie(csrf.CookieName)
+}
+
+// issue 生成新的token，保存到session或者cookie中
+func (csrf *CSRF) issue(context *Context) string {
+	token := randomToken()
+	if csrf.Mode == CSRF_SESSION {
+		context.session.Set(csrfSessionKey, token)
+		return token
+	}
+	// js需要读取cookie放到请求头中，所以不能是HttpOnly
+	context.SetCookie(&http.Cookie{
+		Name:     csrf.CookieName,
+		Value:    token,
+		Domain:   csrf.Domain,
+		Path:     csrf.Path,
+		Secure:   csrf.Secure,
+		SameSite: csrf.SameSite})
+	return token
+}
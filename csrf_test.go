@@ -0,0 +1,150 @@
+package coral
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// testKeyAuth 在请求带有X-Test-Key时设置api key认证的Principal
+func testKeyAuth(context *Context) bool {
+	if context.GetHeader("X-Test-Key") != "" {
+		context.SetPrincipal(&Principal{ID: "k1", Type: AUTH_API_KEY})
+	}
+	return true
+}
+
+// csrfServer 返回使用csrf的server，/form在issue参数不为空时输出token
+func csrfServer(csrf *CSRF, filters ...Filter) *Server {
+	return testServer(func(server *Server) {
+		filters = append(filters, testKeyAuth, csrf.Filter, func(context *Context) bool {
+			if context.Params["issue"] != nil {
+				context.Data = context.CSRFToken()
+			}
+			return true
+		})
+		server.NewRouter("/form", filters...)
+	})
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	server := csrfServer(NewCSRF(CSRF_DOUBLE_SUBMIT))
+	resp := serveTest(server, testRequest("GET", "/form", nil))
+	cookie := resp.Cookie("coral_csrf")
+	if resp.Status != STATUS_SUCCESS || cookie == nil || cookie.Value == "" {
+		t.Fatalf("GET status = %d, cookie = %v", resp.Status, cookie)
+	}
+	if cookie.HttpOnly {
+		t.Errorf("csrf cookie must be readable by js")
+	}
+	token := cookie.Value
+
+	// 已有token时不重新下发
+	req := testRequest("GET", "/form", nil)
+	req.AddCookie(cookie)
+	if serveTest(server, req).Cookie("coral_csrf") != nil {
+		t.Errorf("GET with token reissued cookie")
+	}
+
+	tests := []struct {
+		name   string
+		cookie string
+		header string
+		param  string
+		apiKey bool
+		status int
+	}{
+		{"header", token, token, "", false, STATUS_SUCCESS},
+		{"param", token, "", token, false, STATUS_SUCCESS},
+		{"no submitted token", token, "", "", false, STATUS_INVALID_CSRF},
+		{"mismatch", token, "other", "", false, STATUS_INVALID_CSRF},
+		{"no cookie", "", token, "", false, STATUS_INVALID_CSRF},
+		{"empty cookie and header", "", "", "", false, STATUS_INVALID_CSRF},
+		{"api key exempt", "", "", "", true, STATUS_SUCCESS},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			form := url.Values{}
+			if test.param != "" {
+				form.Set("_csrf", test.param)
+			}
+			req := testRequest("POST", "/form", form)
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "coral_csrf", Value: test.cookie})
+			}
+			if test.header != "" {
+				req.Header.Set("X-CSRF-Token", test.header)
+			}
+			if test.apiKey {
+				req.Header.Set("X-Test-Key", "k1")
+			}
+			if status := serveTest(server, req).Status; status != test.status {
+				t.Errorf("status = %d, want %d", status, test.status)
+			}
+		})
+	}
+}
+
+func TestCSRFSession(t *testing.T) {
+	sessions := NewSessionManager(NewMemorySessionStore())
+	server := csrfServer(NewCSRF(CSRF_SESSION), sessions.Filter)
+
+	// 匿名访问不创建session
+	resp := serveTest(server, testRequest("GET", "/form", nil))
+	if resp.Status != STATUS_SUCCESS || len(resp.Result().Cookies()) > 0 {
+		t.Fatalf("anonymous GET status = %d, cookies = %v",
+			resp.Status, resp.Result().Cookies())
+	}
+
+	// 调用CSRFToken时生成token并保存到session
+	resp = serveTest(server, testRequest("GET", "/form?issue=1", nil))
+	session := resp.Cookie(DefaultSessionCookie)
+	token, _ := resp.Data.(string)
+	if resp.Status != STATUS_SUCCESS || session == nil || token == "" {
+		t.Fatalf("GET status = %d, session = %v, token = %q", resp.Status, session, token)
+	}
+	if resp.Cookie("coral_csrf") != nil {
+		t.Errorf("session mode set csrf cookie")
+	}
+
+	// 已有token时CSRFToken返回同一个token
+	req := testRequest("GET", "/form?issue=1", nil)
+	req.AddCookie(session)
+	if data := serveTest(server, req).Data; data != token {
+		t.Errorf("token changed: %v", data)
+	}
+
+	tests := []struct {
+		name    string
+		session bool
+		header  string
+		status  int
+	}{
+		{"header", true, token, STATUS_SUCCESS},
+		{"no submitted token", true, "", STATUS_INVALID_CSRF},
+		{"mismatch", true, "other", STATUS_INVALID_CSRF},
+		{"no session", false, token, STATUS_INVALID_CSRF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := testRequest("POST", "/form", nil)
+			if test.session {
+				req.AddCookie(session)
+			}
+			if test.header != "" {
+				req.Header.Set("X-CSRF-Token", test.header)
+			}
+			if status := serveTest(server, req).Status; status != test.status {
+				t.Errorf("status = %d, want %d", status, test.status)
+			}
+		})
+	}
+}
+
+func TestCSRFSessionWithoutSessionFilter(t *testing.T) {
+	server := csrfServer(NewCSRF(CSRF_SESSION))
+	status := serveTest(server, testRequest("GET", "/form", nil)).Status
+	if status != STATUS_INVALID_CSRF {
+		t.Errorf("status = %d, want %d", status, STATUS_INVALID_CSRF)
+	}
+}
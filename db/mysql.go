@@ -1,11 +1,14 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 
 	. "github.com/coral/log"
+	"github.com/coral/trace"
 )
 
 // DBPool 类型， 是一个database容器，用于存储服务可能用到的所有db连接池
@@ -96,10 +99,23 @@ func Insert(
 	return DB.Pool[database].Insert(sql, params...)
 }
 
+// Ping 方法，在timeout内检查db的连通性
+func (dbq *DBQuery) Ping(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return dbq.conn.PingContext(ctx)
+}
+
+// Stats 方法，返回连接池的统计信息
+func (dbq *DBQuery) Stats() sql.DBStats {
+	return dbq.conn.Stats()
+}
+
 // Begin 方法，返回DBTransaction对象
 func (dbq *DBQuery) Begin() *DBTransaction {
 	Debug("transaction begin", dbq.database)
 	trans := &DBTransaction{}
+	trans.database = dbq.database
 	conn, err := dbq.conn.Begin()
 	if err != nil {
 		Error("db create transaction faild", dbq.database, err.Error())
@@ -115,7 +131,10 @@ func (dbq *DBQuery) Select(
 	params ...interface{}) []map[string]interface{} {
 
 	Debug("select sql", dbq.database, sql, params)
+	span := startSpan("select", dbq.database, sql)
+	defer span.End()
 	ret, err := dbq.conn.Query(sql, params...)
+	span.SetError(err)
 	return processQueryRet(sql, ret, err)
 }
 
@@ -125,7 +144,10 @@ func (dbq *DBQuery) Update(
 	params ...interface{}) int64 {
 
 	Debug("update sql", dbq.database, sql, params)
+	span := startSpan("update", dbq.database, sql)
+	defer span.End()
 	ret, err := dbq.conn.Exec(sql, params...)
+	span.SetError(err)
 	return processUpdateRet(sql, ret, err)
 }
 
@@ -135,7 +157,10 @@ func (dbq *DBQuery) Insert(
 	params ...interface{}) int64 {
 
 	Debug("insert sql", dbq.database, sql, params)
+	span := startSpan("insert", dbq.database, sql)
+	defer span.End()
 	ret, err := dbq.conn.Exec(sql, params...)
+	span.SetError(err)
 	return processInsertRet(sql, ret, err)
 }
 
@@ -145,7 +170,10 @@ func (dbt *DBTransaction) Select(
 	params ...interface{}) []map[string]interface{} {
 
 	Debug("select sql in transaction", dbt.database, sql, params)
+	span := startSpan("select", dbt.database, sql)
+	defer span.End()
 	ret, err := dbt.conn.Query(sql, params...)
+	span.SetError(err)
 	return processQueryRet(sql, ret, err)
 }
 
@@ -155,7 +183,10 @@ func (dbt *DBTransaction) Update(
 	params ...interface{}) int64 {
 
 	Debug("update sql in transaction", dbt.database, sql, params)
+	span := startSpan("update", dbt.database, sql)
+	defer span.End()
 	ret, err := dbt.conn.Exec(sql, params...)
+	span.SetError(err)
 	return processUpdateRet(sql, ret, err)
 }
 
@@ -165,7 +196,10 @@ func (dbt *DBTransaction) Insert(
 	params ...interface{}) int64 {
 
 	Debug("insert sql in transaction", dbt.database, sql, params)
+	span := startSpan("insert", dbt.database, sql)
+	defer span.End()
 	ret, err := dbt.conn.Exec(sql, params...)
+	span.SetError(err)
 	return processInsertRet(sql, ret, err)
 }
 
@@ -187,6 +221,14 @@ func (dbt *DBTransaction) Rollback() {
 	}
 }
 
+// startSpan 创建sql语句的span
+func startSpan(op, database, query string) *trace.Span {
+	span := trace.Start("db " + op)
+	span.Set("db", database)
+	span.Set("sql", query)
+	return span
+}
+
 // 返回查询结果数组
 func processQueryRet(
 	query string, rows *sql.Rows, err error) []map[string]interface{} {
@@ -2,12 +2,16 @@ package main
 
 import (
 	"flag"
+	"net/http"
+	"strings"
+	"time"
 
 	coral "github.com/coral"
 	cache "github.com/coral/cache"
 	config "github.com/coral/config"
 	db "github.com/coral/db"
 	log "github.com/coral/log"
+	trace "github.com/coral/trace"
 
 	. "github.com/coral/example/constant"
 	filter "github.com/coral/example/filter"
@@ -131,11 +135,47 @@ func initLog() {
 		conf.Int64("log.DEFAULT_LOG_MAX_SIZE"),
 		conf.Int("log.DEFAULT_LOG_MAX_LEVEL"),
 		conf.Int("log.DEFAULT_LOG_MIN_LEVEL"))
+	if conf.Get("log.DEFAULT_LOG_FORMAT") == "json" {
+		log.Log.Pool[DEF_CORAL_LOG].SetFormat(log.FORMAT_JSON)
+	}
+	rotation := &log.Rotation{
+		MaxAge:   time.Duration(conf.Int("log.DEFAULT_LOG_MAX_AGE")) * 24 * time.Hour,
+		MaxTotal: conf.Int64("log.DEFAULT_LOG_MAX_TOTAL"),
+		Compress: conf.Bool("log.DEFAULT_LOG_COMPRESS")}
+	switch conf.Get("log.DEFAULT_LOG_ROTATE") {
+	case "daily":
+		rotation.Period = log.ROTATE_DAILY
+		log.Log.Pool[DEF_CORAL_LOG].SetRotation(rotation)
+	case "hourly":
+		rotation.Period = log.ROTATE_HOURLY
+		log.Log.Pool[DEF_CORAL_LOG].SetRotation(rotation)
+	}
+	if conf.Bool("log.DEFAULT_LOG_ASYNC") {
+		async := &log.Async{BufferSize: conf.Int("log.DEFAULT_LOG_ASYNC_BUFFER")}
+		if conf.Bool("log.DEFAULT_LOG_ASYNC_DROP") {
+			async.Policy = log.ASYNC_DROP
+		}
+		log.Log.Pool[DEF_CORAL_LOG].SetAsync(async)
+	}
+	log.SetStdLog(conf.Bool("log.DEFAULT_LOG_STDLOG"))
 
 	// add other logger
 	// ...
 }
 
+func initStatus() {
+	// register user status
+	coral.RegisterStatus(STATUS_INVALID_INPUT, "STATUS_INVALID_INPUT",
+		map[string]string{"en": "invalid input", "zh": "输入参数错误"})
+	coral.RegisterStatus(STATUS_INVALID_OUTPUT, "STATUS_INVALID_OUTPUT",
+		map[string]string{"en": "invalid output", "zh": "输出结果错误"})
+
+	// map user status to http status
+	coral.MapStatusRange(10000, 19999, http.StatusInternalServerError, "")
+	coral.MapStatusRange(20000, 29999, http.StatusBadRequest, "")
+	coral.MapStatus(STATUS_INVALID_OUTPUT, http.StatusInternalServerError, "")
+}
+
 func main() {
 	confFile := flag.String("ini", "./config/config.ini", "your config file")
 	flag.Parse()
@@ -152,12 +192,46 @@ func main() {
 		// init redis
 		initRedis()
 
+		// init status
+		initStatus()
+
+		// init trace
+		if file := conf.Get("trace.FILE"); file != "" {
+			if exporter, err := trace.NewFileExporter(file); err == nil {
+				trace.SetExporter(exporter)
+			}
+		}
+
 		// new server
 		server := coral.NewServer(conf.Get("server.HOST"))
+		if conf.Bool("server.HTTP_STATUS") {
+			server.EnableHTTPStatus()
+		}
+		server.SetTrustedProxies(
+			strings.Split(conf.Get("server.TRUSTED_PROXIES"), ",")...)
+		server.SetCORS(coral.NewCORS(conf, "cors"))
+		server.SetAccessLog(coral.NewAccessLog(conf, "access_log"))
+		if conf.Bool("server.HEALTH") {
+			server.EnableHealth()
+		}
+		if conf.Bool("server.METRICS") {
+			server.EnableMetrics()
+		}
+		server.SetShutdown(
+			time.Duration(conf.Int("server.SHUTDOWN_DELAY"))*time.Second,
+			time.Duration(conf.Int("server.SHUTDOWN_TIMEOUT"))*time.Second)
+		if size := conf.Int("server.COMPRESS_SIZE"); size > 0 {
+			server.EnableCompression(size)
+		}
 
 		// new router
 		initRouter(server)
 
+		// start admin server
+		if host := conf.Get("admin.HOST"); host != "" {
+			go coral.NewAdminServer(host, server).Run()
+		}
+
 		// start server
 		server.Run()
 	} else {
@@ -0,0 +1,208 @@
+package coral
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coral/cache"
+	"github.com/coral/db"
+	. "github.com/coral/log"
+)
+
+// 健康检查的路由
+const (
+	LivePath   = "/livez"   // 存活检查，进程在服务就返回200
+	HealthPath = "/healthz" // 健康检查，所有依赖正常时返回200
+	ReadyPath  = "/readyz"  // 就绪检查，依赖正常且没有在停止时返回200
+)
+
+// DefaultHealthTimeout 是每个检查的默认超时时间
+const DefaultHealthTimeout = 2 * time.Second
+
+// HealthCheck 是自定义的健康检查，返回nil表示正常
+type HealthCheck func() error
+
+// healthCheck 是一个已添加的检查
+type healthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// healthResult 是一个检查的结果
+type healthResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// healthReport 是健康检查的输出
+type healthReport struct {
+	Status string                   `json:"status"`
+	Checks map[string]*healthResult `json:"checks,omitempty"`
+}
+
+var errHealthTimeout = errors.New("health check timeout")
+
+// EnableHealth 开启健康检查的路由，在Run时注册
+// 已经有路由的path不会注册，对外只返回状态，检查详情通过管理server的/debug/health查看
+func (server *Server) EnableHealth() {
+	server.health = true
+}
+
+// AddHealthCheck 添加一个自定义的健康检查
+// 所有db.DB.Pool和cache.Cache.Pool中的连接会自动检查，不需要添加
+func (server *Server) AddHealthCheck(name string, check HealthCheck) {
+	server.healthChecks = append(server.healthChecks,
+		&healthCheck{name: name, check: check})
+}
+
+// SetHealthTimeout 指定每个健康检查的超时时间
+func (server *Server) SetHealthTimeout(timeout time.Duration) {
+	server.healthTimeout = timeout
+}
+
+// registerHealth 注册健康检查的路由，跳过已经有路由的path
+func (server *Server) registerHealth() {
+	if !server.health {
+		return
+	}
+	handlers := map[string]http.HandlerFunc{
+		LivePath:   server.live,
+		HealthPath: server.healthz,
+		ReadyPath:  server.ready}
+	for _, path := range []string{LivePath, HealthPath, ReadyPath} {
+		if server.routed(path) {
+			Warn("health path already routed, skip", path)
+			continue
+		}
+		Info("register health", path)
+		server.mux.HandleFunc(path, handlers[path])
+	}
+}
+
+// routed 判断path是否已经有完全匹配的路由
+func (server *Server) routed(path string) bool {
+	_, pattern := server.mux.Handler(&http.Request{Method: "GET", URL: &url.URL{Path: path}})
+	return pattern == path
+}
+
+func (server *Server) live(w http.ResponseWriter, req *http.Request) {
+	writeHealth(w, &healthReport{Status: "ok"})
+}
+
+// healthz 和 ready 对外只返回状态，不输出依赖的名称和错误
+func (server *Server) healthz(w http.ResponseWriter, req *http.Request) {
+	writeHealth(w, &healthReport{Status: server.checkHealth().Status})
+}
+
+func (server *Server) ready(w http.ResponseWriter, req *http.Request) {
+	if atomic.LoadInt32(&server.shuttingDown) == 1 {
+		writeHealth(w, &healthReport{Status: "shutting down"})
+		return
+	}
+	writeHealth(w, &healthReport{Status: server.checkHealth().Status})
+}
+
+// checks 返回所有需要执行的检查，包括所有db和redis
+func (server *Server) checks() []*healthCheck {
+	var checks []*healthCheck
+	timeout := server.timeout()
+	for name, dbq := range db.DB.Pool {
+		dbq := dbq
+		checks = append(checks, &healthCheck{
+			name: "db." + name,
+			check: func() error {
+				return dbq.Ping(timeout)
+			}})
+	}
+	for name := range cache.Cache.Pool {
+		name := name
+		checks = append(checks, &healthCheck{
+			name: "redis." + name,
+			check: func() error {
+				return cache.Ping(name, timeout)
+			}})
+	}
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+	return append(checks, server.healthChecks...)
+}
+
+func (server *Server) timeout() time.Duration {
+	if server.healthTimeout > 0 {
+		return server.healthTimeout
+	}
+	return DefaultHealthTimeout
+}
+
+// checkHealth 并发执行所有检查，超时的检查视为失败
+func (server *Server) checkHealth() *healthReport {
+	checks := server.checks()
+	report := &healthReport{
+		Status: "ok",
+		Checks: make(map[string]*healthResult, len(checks))}
+	mux := new(sync.Mutex)
+	wg := new(sync.WaitGroup)
+	timeout := server.timeout()
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check *healthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			result := &healthResult{Status: "ok"}
+			if err := runCheck(check.check, timeout); err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+			result.Latency = time.Now().Sub(start).String()
+			mux.Lock()
+			defer mux.Unlock()
+			report.Checks[check.name] = result
+			if result.Status != "ok" {
+				report.Status = "fail"
+				Warn("health check faild", check.name, result.Error)
+			}
+		}(check)
+	}
+	wg.Wait()
+	return report
+}
+
+// runCheck 执行检查，超过timeout直接返回，检查本身在后台结束
+func runCheck(check HealthCheck, timeout time.Duration) (err error) {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- errors.New("health check panic")
+			}
+		}()
+		done <- check()
+	}()
+	select {
+	case err = <-done:
+		return err
+	case <-time.After(timeout):
+		return errHealthTimeout
+	}
+}
+
+func writeHealth(w http.ResponseWriter, report *healthReport) {
+	out, err := json.Marshal(report)
+	if err != nil {
+		Error(err)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(out)
+}
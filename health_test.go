@@ -0,0 +1,167 @@
+package coral
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// healthServer 创建开启健康检查的server，checks为自定义检查
+func healthServer(checks map[string]HealthCheck) *Server {
+	server := testServer(func(server *Server) {
+		server.EnableHealth()
+		server.SetHealthTimeout(50 * time.Millisecond)
+		for name, check := range checks {
+			server.AddHealthCheck(name, check)
+		}
+	})
+	server.registerHealth()
+	return server
+}
+
+func TestHealth(t *testing.T) {
+	ok := func() error { return nil }
+	tests := []struct {
+		name   string
+		checks map[string]HealthCheck
+		code   int
+		detail map[string]string // 检查名 -> 错误
+	}{
+		{"no checks", nil, http.StatusOK, map[string]string{}},
+		{"ok", map[string]HealthCheck{"a": ok}, http.StatusOK, map[string]string{"a": ""}},
+		{"fail", map[string]HealthCheck{"a": ok, "b": func() error {
+			return errors.New("b down")
+		}}, http.StatusServiceUnavailable, map[string]string{"a": "", "b": "b down"}},
+		{"panic", map[string]HealthCheck{"a": func() error {
+			panic("boom")
+		}}, http.StatusServiceUnavailable, map[string]string{"a": "health check panic"}},
+		{"timeout", map[string]HealthCheck{"a": func() error {
+			time.Sleep(time.Second)
+			return nil
+		}}, http.StatusServiceUnavailable, map[string]string{"a": errHealthTimeout.Error()}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := healthServer(test.checks)
+			live := serveTest(server, testRequest("GET", LivePath, nil))
+			if live.Code != http.StatusOK {
+				t.Errorf("livez = %d", live.Code)
+			}
+			for _, path := range []string{HealthPath, ReadyPath} {
+				resp := serveTest(server, testRequest("GET", path, nil))
+				if resp.Code != test.code {
+					t.Errorf("%s = %d, want %d", path, resp.Code, test.code)
+				}
+				// 对外不输出检查的名称和错误
+				if strings.Contains(resp.Body.String(), "checks") {
+					t.Errorf("%s exposes details: %s", path, resp.Body.String())
+				}
+			}
+
+			admin := NewAdminServer("", server)
+			resp := serveTest(admin, testRequest("GET", "/debug/health", nil))
+			var report healthReport
+			if err := json.Unmarshal(resp.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Checks) != len(test.detail) {
+				t.Errorf("checks = %v, want %v", report.Checks, test.detail)
+			}
+			for name, errmsg := range test.detail {
+				if result := report.Checks[name]; result == nil || result.Error != errmsg {
+					t.Errorf("check %s = %+v, want error %q", name, result, errmsg)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthRouted(t *testing.T) {
+	server := testServer(func(server *Server) {
+		server.EnableHealth()
+		server.NewRouter(HealthPath, func(context *Context) bool {
+			context.Data = "custom"
+			return true
+		})
+	})
+	server.registerHealth()
+	if data := serveTest(server, testRequest("GET", HealthPath, nil)).Data; data != "custom" {
+		t.Errorf("healthz data = %v, want custom route kept", data)
+	}
+	if code := serveTest(server, testRequest("GET", LivePath, nil)).Code; code != http.StatusOK {
+		t.Errorf("livez = %d", code)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := healthServer(nil)
+	server.NewRouter("/slow", func(context *Context) bool {
+		close(started)
+		<-release
+		context.Data = "done"
+		return true
+	})
+	server.registerRouters()
+	ts := httptest.NewServer(server.mux)
+	defer ts.Close()
+	server.httpServer = ts.Config
+	server.stopped = make(chan struct{})
+	server.SetShutdown(50*time.Millisecond, 5*time.Second)
+
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(ts.URL + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		slow <- string(body)
+	}()
+	<-started
+
+	var stopped int32
+	go func() {
+		server.Shutdown()
+		atomic.StoreInt32(&stopped, 1)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	// delay期间就绪检查失败，存活检查正常
+	if code := serveTest(server, testRequest("GET", ReadyPath, nil)).Code; code != http.StatusServiceUnavailable {
+		t.Errorf("readyz while shutting down = %d, want 503", code)
+	}
+	if code := serveTest(server, testRequest("GET", LivePath, nil)).Code; code != http.StatusOK {
+		t.Errorf("livez while shutting down = %d, want 200", code)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&stopped) == 1 {
+		t.Fatal("shutdown returned before the request finished")
+	}
+	close(release)
+	select {
+	case body := <-slow:
+		if !strings.Contains(body, "done") {
+			t.Errorf("in-flight request = %q", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request did not finish")
+	}
+	select {
+	case <-server.stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not finish")
+	}
+	if _, err := http.Get(ts.URL + "/slow"); err == nil {
+		t.Errorf("new request accepted after shutdown")
+	}
+}
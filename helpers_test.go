@@ -0,0 +1,80 @@
+package coral
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServer 创建server，routes添加路由后注册到mux
+func testServer(routes func(server *Server)) *Server {
+	server := NewServer("")
+	routes(server)
+	server.registerRouters()
+	return server
+}
+
+// testRequest 创建请求，form不为nil时作为表单提交
+func testRequest(method, target string, form url.Values) *http.Request {
+	if form == nil {
+		return httptest.NewRequest(method, target, nil)
+	}
+	req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// testResponse 是测试请求的响应，不是json响应时Status为-1
+type testResponse struct {
+	*httptest.ResponseRecorder
+	Status int
+	Data   interface{}
+}
+
+// serveTest 由server处理req
+func serveTest(server *Server, req *http.Request) *testResponse {
+	rec := httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, req)
+	resp := &testResponse{ResponseRecorder: rec, Status: -1}
+	var body Response
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err == nil {
+		resp.Status = body.Status
+		resp.Data = body.Data
+	}
+	return resp
+}
+
+// Cookie 返回响应设置的cookie，没有时返回nil
+func (resp *testResponse) Cookie(name string) *http.Cookie {
+	for _, cookie := range resp.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+var testJWTSecret = []byte("secret")
+
+// testJWTAuth 返回使用testJWTSecret验证的JWTAuth
+func testJWTAuth() *JWTAuth {
+	auth := &JWTAuth{}
+	auth.AddKey("k1", "HS256", testJWTSecret)
+	return auth
+}
+
+// testToken 返回带有roles的有效token
+func testToken(t *testing.T, roles string) string {
+	token, err := SignJWT("HS256", "k1", testJWTSecret, map[string]interface{}{
+		"sub":   "u1",
+		"roles": roles,
+		"exp":   time.Now().Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
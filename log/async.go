@@ -0,0 +1,137 @@
+package log
+
+// 异步写入日志
+//
+// 异步logger的日志先放入有界队列，由单独的goroutine写入缓冲并定期写入文件
+// 队列满时按Policy等待或者丢弃，ERROR和FATAL级别的日志总是等待，丢弃的条数可以通过Dropped取得
+// Flush等待队列中的日志全部写入文件，Fatal和server停止时会自动调用
+// 缓冲只由写入goroutine使用，同步写入的goroutine发现已经切换为异步时改为放入队列
+
+import (
+	"bufio"
+	"sync/atomic"
+	"time"
+)
+
+// 队列满时的策略
+const (
+	ASYNC_BLOCK = iota // 等待队列有空间
+	ASYNC_DROP         // 丢弃日志
+)
+
+// 异步写入的默认配置
+const (
+	DefaultAsyncBufferSize    = 4096
+	DefaultAsyncFlushInterval = time.Second
+	asyncWriterSize           = 64 << 10 // 写入文件的缓冲大小
+)
+
+// Async 是异步写入的配置
+type Async struct {
+	BufferSize    int           // 队列中最多的日志条数，默认DefaultAsyncBufferSize
+	Policy        int           // 队列满时的策略，ASYNC_BLOCK或ASYNC_DROP
+	FlushInterval time.Duration // 缓冲写入文件的间隔，默认DefaultAsyncFlushInterval
+}
+
+// logItem 是队列中的一条日志，done不为nil时表示Flush请求
+type logItem struct {
+	line string
+	done chan struct{}
+}
+
+// dropped 队列满时丢弃的日志条数
+var dropped int64
+
+// Dropped 返回异步logger队列满时丢弃的日志条数
+func Dropped() int64 {
+	return atomic.LoadInt64(&dropped)
+}
+
+// SetAsync 指定logger异步写入，只能设置一次
+func (lg *Logger) SetAsync(async *Async) {
+	config := *async
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultAsyncBufferSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultAsyncFlushInterval
+	}
+	lg.mux.Lock()
+	defer lg.mux.Unlock()
+	if lg.queue != nil {
+		return
+	}
+	lg.async = &config
+	lg.queue = make(chan *logItem, config.BufferSize)
+	lg.buf = bufio.NewWriterSize(lg.logFile, asyncWriterSize)
+	go lg.writeLoop(lg.queue, config.FlushInterval)
+}
+
+// enqueue 将日志放入队列，队列满时按policy等待或者丢弃
+func enqueue(queue chan *logItem, item *logItem, policy int) {
+	if policy == ASYNC_DROP {
+		select {
+		case queue <- item:
+		default:
+			atomic.AddInt64(&dropped, 1)
+		}
+		return
+	}
+	queue <- item
+}
+
+// writeLoop 从队列中取出日志写入缓冲，并定期将缓冲写入文件
+func (lg *Logger) writeLoop(queue chan *logItem, interval time.Duration) {
+	atomic.StoreInt64(&lg.writer, GoroutineID())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case item := <-queue:
+			if item.done != nil {
+				lg.flushBuffer()
+				close(item.done)
+				continue
+			}
+			lg.rotate()
+			lg.mux.RLock()
+			lg.write(item.line)
+			lg.mux.RUnlock()
+		case <-ticker.C:
+			lg.flushBuffer()
+		}
+	}
+}
+
+// flushBuffer 将缓冲写入文件
+func (lg *Logger) flushBuffer() {
+	lg.mux.RLock()
+	defer lg.mux.RUnlock()
+	if err := lg.buf.Flush(); err != nil {
+		atomic.AddInt64(&writeErrors, 1)
+	}
+}
+
+// Flush 等待异步logger队列中的日志全部写入文件，同步logger直接返回
+// 在写入goroutine中调用时直接返回，否则会等待自己
+func (lg *Logger) Flush() {
+	lg.mux.RLock()
+	queue := lg.queue
+	lg.mux.RUnlock()
+	if queue == nil || atomic.LoadInt64(&lg.writer) == GoroutineID() {
+		return
+	}
+	done := make(chan struct{})
+	queue <- &logItem{done: done}
+	<-done
+}
+
+// Flush 等待所有异步logger的日志写入文件
+func Flush() {
+	if Log == nil {
+		return
+	}
+	for _, logger := range Log.Pool {
+		logger.Flush()
+	}
+}
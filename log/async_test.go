@@ -0,0 +1,182 @@
+package log
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAsyncPolicy(t *testing.T) {
+	logger, _, remove := testLogger(t, "policy.log")
+	defer remove()
+	// 不启动写入goroutine，队列只能由测试取出
+	queue := make(chan *logItem, 1)
+	logger.mux.Lock()
+	logger.async = &Async{BufferSize: 1, Policy: ASYNC_DROP}
+	logger.queue = queue
+	logger.mux.Unlock()
+
+	before := Dropped()
+	logger.writeLine("a\n", INFO)
+	logger.writeLine("b\n", INFO)
+	if Dropped() != before+1 {
+		t.Errorf("dropped = %d, want %d", Dropped(), before+1)
+	}
+
+	// ERROR不丢弃，等待队列有空间
+	done := make(chan struct{})
+	go func() {
+		logger.writeLine("c\n", ERROR)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("error log not blocked on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if item := <-queue; item.line != "a\n" {
+		t.Errorf("first item = %q", item.line)
+	}
+	<-done
+	if item := <-queue; item.line != "c\n" || Dropped() != before+1 {
+		t.Errorf("second item = %q, dropped = %d", item.line, Dropped())
+	}
+
+	// ASYNC_BLOCK时INFO也等待
+	logger.mux.Lock()
+	logger.async.Policy = ASYNC_BLOCK
+	logger.mux.Unlock()
+	logger.writeLine("d\n", INFO)
+	done = make(chan struct{})
+	go func() {
+		logger.writeLine("e\n", INFO)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("blocking policy dropped or returned early")
+	case <-time.After(50 * time.Millisecond):
+	}
+	<-queue
+	<-done
+	if item := <-queue; item.line != "e\n" || Dropped() != before+1 {
+		t.Errorf("blocked item = %q, dropped = %d", item.line, Dropped())
+	}
+}
+
+func TestAsyncFlush(t *testing.T) {
+	logger, dir, remove := testLogger(t, "flush.log")
+	defer remove()
+	logger.SetAsync(&Async{FlushInterval: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		logger.Raw("line")
+	}
+	// FlushInterval为1小时，Flush之前日志只在队列或缓冲中
+	if content, _ := ioutil.ReadFile(dir + "/flush.log"); len(content) > 0 {
+		t.Fatalf("content before Flush = %q", content)
+	}
+	logger.Flush()
+	content, _ := ioutil.ReadFile(dir + "/flush.log")
+	if string(content) != "line\nline\nline\n" {
+		t.Errorf("content after Flush = %q", content)
+	}
+}
+
+func TestFlushFromWriter(t *testing.T) {
+	logger, _, remove := testLogger(t, "writer.log")
+	defer remove()
+	queue := make(chan *logItem, 1)
+	logger.mux.Lock()
+	logger.async = &Async{BufferSize: 1}
+	logger.queue = queue
+	logger.mux.Unlock()
+
+	// 在写入goroutine中调用时直接返回，不等待自己
+	atomic.StoreInt64(&logger.writer, GoroutineID())
+	logger.Flush()
+	if len(queue) > 0 {
+		t.Fatalf("Flush in writer goroutine queued a request")
+	}
+
+	// 其他goroutine等待写入goroutine处理Flush请求
+	done := make(chan struct{})
+	go func() {
+		logger.Flush()
+		close(done)
+	}()
+	item := <-queue
+	select {
+	case <-done:
+		t.Fatal("Flush returned before the request was handled")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(item.done)
+	<-done
+}
+
+// TestFatalFlush 在子进程中调用Fatal，Fatal在退出前写入异步logger中的所有日志
+func TestFatalFlush(t *testing.T) {
+	if dir := os.Getenv("CORAL_LOG_FATAL_DIR"); dir != "" {
+		SetStdLog(false)
+		AddLogger("fatal.log", dir, 3, 1<<20, FATAL, DEBUG)
+		Log.Pool["fatal.log"].SetAsync(&Async{FlushInterval: time.Hour})
+		Info("before fatal")
+		Fatal("fatal")
+		return
+	}
+	dir, err := ioutil.TempDir("", "coral-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestFatalFlush$")
+	cmd.Env = append(os.Environ(), "CORAL_LOG_FATAL_DIR="+dir)
+	out, err := cmd.CombinedOutput()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.Sys() == nil || exit.ExitCode() != 1 {
+		t.Fatalf("err = %v, output %s", err, out)
+	}
+	content, _ := ioutil.ReadFile(dir + "/fatal.log")
+	if !strings.Contains(string(content), "[INFO] before fatal") ||
+		!strings.Contains(string(content), "[FATAL] fatal") {
+		t.Errorf("content = %q", content)
+	}
+	if bytes.Contains(out, []byte("before fatal")) {
+		t.Errorf("std log not disabled: %s", out)
+	}
+}
+
+func TestStdLog(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetStdLog(true)
+
+	logger, dir, remove := testLogger(t, "std.log")
+	defer remove()
+	logger.SetLevel(WARN, INFO)
+
+	logger.Info("to both")
+	SetStdLog(false)
+	logger.Info("file only")
+	logger.Debug("nowhere")
+	SetStdLog(true)
+	logger.Debug("std only")
+
+	std := buf.String()
+	if !strings.Contains(std, "[INFO] to both") || !strings.Contains(std, "[DEBUG] std only") ||
+		strings.Contains(std, "file only") || strings.Contains(std, "nowhere") {
+		t.Errorf("std log = %q", std)
+	}
+	content, _ := ioutil.ReadFile(dir + "/std.log")
+	if !strings.Contains(string(content), "to both") || !strings.Contains(string(content), "file only") ||
+		strings.Contains(string(content), "nowhere") || strings.Contains(string(content), "std only") {
+		t.Errorf("file = %q", content)
+	}
+}
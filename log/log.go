@@ -13,14 +13,22 @@ package log
 // Error 有callstack，可指定logger
 // Fatal 有callstack，且服务会停止
 // Callstack 直接输出当前callstack，可指定logger
+//
+// With 返回带有字段的Entry，用于输出结构化日志，如
+// log.With(log.Fields{"user": 1}).Info("login")
+// 每个logger可以通过SetFormat指定输出文本或者json
 
 import (
+	"bufio"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -33,6 +41,12 @@ const (
 	FATAL
 )
 
+// 日志输出格式
+const (
+	FORMAT_TEXT = iota // [LEVEL] [tag] msg key=value
+	FORMAT_JSON        // 每行一个json，包括time，level，caller，tag，msg和所有字段
+)
+
 // LogPool 日志池对象
 type LogPool struct {
 	Pool map[string]*Logger
@@ -47,15 +61,30 @@ type Logger struct {
 	maxNumber int    // 日志最大文件数，超过则循环替代
 	maxSize   int64  // 日志单个文件最大size，单位byte
 
+	format int // 输出格式，FORMAT_TEXT或FORMAT_JSON
+
 	suffix  int           // 当前rotate后缀
 	mux     *sync.RWMutex // 并发锁
 	logFile *os.File      // 日志文件指针
-	logger  *log.Logger   // go的logger指针
+
+	size        int64       // 当前文件的大小，避免每次写入都读取文件信息
+	rotation    *Rotation   // 按时间切分的配置，为nil时按大小循环切分
+	periodStart time.Time   // 当前文件所在周期的开始时间
+	part        int         // 当前周期内按大小切分的序号
+	cleanMux    *sync.Mutex // 保证同时只有一个clean在执行
+
+	async  *Async        // 异步写入的配置，为nil时同步写入
+	queue  chan *logItem // 异步写入的队列
+	buf    *bufio.Writer // 异步写入的缓冲，只由写入goroutine使用
+	writer int64         // 写入goroutine的id
 }
 
 // Log 全局变量
 var Log *LogPool
 
+// writeErrors 日志写入文件失败的次数
+var writeErrors int64
+
 func init() {
 	if Log != nil {
 		return
@@ -76,6 +105,7 @@ func AddLogger(
 	logger.path = path
 	logger.filename = name
 	logger.logFile = openFile(path, name)
+	logger.size = fileSize(path + "/" + name)
 	logger.maxNumber = maxNumber
 	logger.maxSize = maxSize
 	logger.maxLevel = maxLevel
@@ -83,7 +113,7 @@ func AddLogger(
 
 	logger.suffix = 0
 	logger.mux = new(sync.RWMutex)
-	logger.logger = log.New(logger.logFile, "", log.Ldate|log.Ltime)
+	logger.cleanMux = new(sync.Mutex)
 
 	Log.Pool[name] = logger
 }
@@ -91,112 +121,261 @@ func AddLogger(
 // logInfo是为了可变参数输出而定义的接口数据类型
 type logInfo []interface{}
 
-func baseLog(level int, prefix string, msg ...interface{}) {
-	msg = append(logInfo{prefix}, msg...)
-	if Log != nil {
-		Log.log(level, msg...)
+// stdLog 为1时日志同时输出到标准日志
+var stdLog int32 = 1
+
+// SetStdLog 指定日志是否同时输出到标准日志，默认输出
+// 标准日志同步写入，日志量大时可以关闭，只写入logger
+func SetStdLog(on bool) {
+	if on {
+		atomic.StoreInt32(&stdLog, 1)
+	} else {
+		atomic.StoreInt32(&stdLog, 0)
+	}
+}
+
+// output 将日志输出到指定的logger，logger为nil时输出到所有logger
+// 同时输出到标准日志，没有logger接受并且不输出到标准日志时直接返回
+// 调用位置只在有json格式的logger时取得，callstack只在有地方输出时取得
+func output(logger *Logger, level int, fields Fields, stack bool, msg []interface{}) {
+	var loggers []*Logger
+	if logger != nil {
+		loggers = []*Logger{logger}
+	} else if Log != nil {
+		loggers = make([]*Logger, 0, len(Log.Pool))
+		for _, lg := range Log.Pool {
+			loggers = append(loggers, lg)
+		}
+	}
+	accepted := loggers[:0]
+	needCaller := false
+	for _, lg := range loggers {
+		if format, ok := lg.accept(level); ok {
+			accepted = append(accepted, lg)
+			needCaller = needCaller || format == FORMAT_JSON
+		}
+	}
+	std := atomic.LoadInt32(&stdLog) == 1
+	if len(accepted) < 1 && !std {
+		return
+	}
+
+	rec := newRecord(level, fields, msg)
+	if needCaller {
+		rec.caller = caller()
+	}
+	if stack {
+		rec.callstack = getCallstack()
+	}
+	for _, lg := range accepted {
+		lg.log(rec)
+	}
+	if !std {
+		return
+	}
+	if rec.level != ALL {
+		log.Print(rec.text())
+	}
+	if rec.callstack != nil {
+		log.Print(rec.stackText())
 	}
-	log.Println(msg...)
 }
 
 func Debug(msg ...interface{}) {
-	baseLog(DEBUG, "[DEBUG]", msg...)
-	Callstack()
+	output(nil, DEBUG, nil, true, msg)
 }
 
 func Info(msg ...interface{}) {
-	baseLog(INFO, "[INFO]", msg...)
+	output(nil, INFO, nil, false, msg)
 }
 
 func Warn(msg ...interface{}) {
-	baseLog(WARN, "[WARN]", msg...)
-	Callstack()
+	output(nil, WARN, nil, true, msg)
 }
 
 func Error(msg ...interface{}) {
-	baseLog(ERROR, "[ERROR]", msg...)
-	Callstack()
+	output(nil, ERROR, nil, true, msg)
 }
 
 func Fatal(msg ...interface{}) {
-	baseLog(FATAL, "[FATAL]", msg...)
-	Callstack()
+	output(nil, FATAL, nil, true, msg)
+	Flush()
 	os.Exit(1)
 }
 
 func Callstack() {
-	msg := getCallstack()
-	baseLog(ALL, "", msg...)
-}
-
-func (lp *LogPool) log(level int, msg ...interface{}) {
-	for _, logger := range lp.Pool {
-		if logger.logger != nil &&
-			((logger.maxLevel >= level && logger.minLevel <= level) ||
-				level == ALL) {
-			logger.rotate()
-			logger.mux.RLock()
-			defer logger.mux.RUnlock()
-			logger.logger.Println(msg...)
-		}
-	}
+	output(nil, ALL, nil, true, nil)
 }
 
 func (lg *Logger) Debug(msg ...interface{}) {
-	lg.log(DEBUG, "[DEBUG]", msg...)
-	lg.Callstack()
+	output(lg, DEBUG, nil, true, msg)
 }
 
 func (lg *Logger) Info(msg ...interface{}) {
-	lg.log(INFO, "[INFO]", msg...)
+	output(lg, INFO, nil, false, msg)
 }
 
 func (lg *Logger) Warn(msg ...interface{}) {
-	lg.log(WARN, "[WARN]", msg...)
-	lg.Callstack()
+	output(lg, WARN, nil, true, msg)
 }
 
 func (lg *Logger) Error(msg ...interface{}) {
-	lg.log(ERROR, "[ERROR]", msg...)
-	lg.Callstack()
+	output(lg, ERROR, nil, true, msg)
 }
 
 func (lg *Logger) Callstack() {
-	msg := getCallstack()
-	lg.log(ALL, "", msg...)
-}
-
-func (logger *Logger) log(level int, prefix string, msg ...interface{}) {
-	msg = append(logInfo{prefix}, msg...)
-	if logger.logger != nil &&
-		((logger.maxLevel >= level && logger.minLevel <= level) ||
-			level == ALL) {
-		logger.rotate()
-		logger.mux.RLock()
-		defer logger.mux.RUnlock()
-		logger.logger.Println(msg...)
+	output(lg, ALL, nil, true, nil)
+}
+
+// log 按logger的格式写入一条日志，callstack跟随日志写入
+func (lg *Logger) log(rec *record) {
+	lg.mux.RLock()
+	format := lg.format
+	lg.mux.RUnlock()
+	lg.writeLine(rec.line(format), rec.level)
+}
+
+// Raw 将line原样写入日志文件，不加时间，级别和标记，也不输出到标准日志
+// 不受日志级别和输出格式的影响，用于访问日志等有固定格式的日志
+func (lg *Logger) Raw(line string) {
+	if !strings.HasSuffix(line, "\n") {
+		line = line + "\n"
+	}
+	lg.writeLine(line, INFO)
+}
+
+// writeLine 写入一行日志
+// 异步logger只把日志放入队列，由写入goroutine写入文件
+func (lg *Logger) writeLine(line string, level int) {
+	lg.mux.RLock()
+	opened := lg.logFile != nil
+	async, queue := lg.async, lg.queue
+	lg.mux.RUnlock()
+	if !opened {
+		return
+	}
+	if queue != nil {
+		policy := async.Policy
+		if level >= ERROR {
+			// 错误日志不丢弃
+			policy = ASYNC_BLOCK
+		}
+		enqueue(queue, &logItem{line: line}, policy)
+		return
+	}
+	lg.rotate()
+	lg.mux.RLock()
+	if queue = lg.queue; queue == nil {
+		lg.write(line)
+		lg.mux.RUnlock()
+		return
 	}
-	log.Println(msg...)
+	// 等待锁的过程中切换成了异步写入，缓冲只能由写入goroutine使用
+	lg.mux.RUnlock()
+	enqueue(queue, &logItem{line: line}, ASYNC_BLOCK)
+}
+
+// accept 判断logger是否接受该级别的日志，同时返回logger的输出格式
+func (lg *Logger) accept(level int) (int, bool) {
+	lg.mux.RLock()
+	defer lg.mux.RUnlock()
+	return lg.format, (lg.maxLevel >= level && lg.minLevel <= level) || level == ALL
+}
+
+// SetLevel 修改logger接受的日志级别，运行中也可以修改
+func (lg *Logger) SetLevel(maxLevel, minLevel int) {
+	lg.mux.Lock()
+	defer lg.mux.Unlock()
+	lg.maxLevel = maxLevel
+	lg.minLevel = minLevel
+}
+
+// Level 返回logger接受的最高和最低日志级别
+func (lg *Logger) Level() (int, int) {
+	lg.mux.RLock()
+	defer lg.mux.RUnlock()
+	return lg.maxLevel, lg.minLevel
+}
+
+// SetFormat 指定logger的输出格式，FORMAT_TEXT或FORMAT_JSON
+func (lg *Logger) SetFormat(format int) {
+	lg.mux.Lock()
+	defer lg.mux.Unlock()
+	lg.format = format
+}
+
+// write 写入日志文件，异步logger先写入缓冲，失败时计数
+func (lg *Logger) write(line string) {
+	var err error
+	if lg.buf != nil {
+		_, err = lg.buf.WriteString(line)
+	} else {
+		_, err = lg.logFile.WriteString(line)
+	}
+	if err != nil {
+		atomic.AddInt64(&writeErrors, 1)
+	}
+	atomic.AddInt64(&lg.size, int64(len(line)))
+}
+
+// WriteErrors 返回日志写入文件失败的次数
+func WriteErrors() int64 {
+	return atomic.LoadInt64(&writeErrors)
 }
 
 func (lg *Logger) rotate() {
+	lg.mux.RLock()
+	rotation := lg.rotation
+	lg.mux.RUnlock()
+	if rotation != nil {
+		lg.rotateByTime()
+		return
+	}
+	if atomic.LoadInt64(&lg.size) <= lg.maxSize {
+		return
+	}
+	lg.mux.Lock()
+	defer lg.mux.Unlock()
+	// 等待锁的过程中可能已经切分过了
+	if atomic.LoadInt64(&lg.size) <= lg.maxSize {
+		return
+	}
+	lg.suffix = int((lg.suffix + 1) % lg.maxNumber)
+	tarFilename := lg.path + "/" + lg.filename + "." + strconv.Itoa(int(lg.suffix))
+	//is file exist, remove it
+	if fileIsExist(tarFilename) {
+		os.Remove(tarFilename)
+	}
+	lg.switchFile(tarFilename)
+}
+
+// switchFile 将当前文件重命名为tarFilename并打开新文件，需要持有写锁
+// 缓冲中的日志先写入当前文件，新文件打开失败时继续写入当前文件
+// 持有锁时不能调用Error和Fatal，错误只输出到标准日志
+func (lg *Logger) switchFile(tarFilename string) bool {
 	curFilename := lg.path + "/" + lg.filename
-	if fileSize(curFilename) > lg.maxSize {
-		lg.mux.Lock()
-		defer lg.mux.Unlock()
-		lg.suffix = int((lg.suffix + 1) % lg.maxNumber)
-		if lg.logFile != nil {
-			lg.logFile.Close()
+	if lg.buf != nil {
+		if err := lg.buf.Flush(); err != nil {
+			atomic.AddInt64(&writeErrors, 1)
 		}
-		tarFilename := curFilename + "." + strconv.Itoa(int(lg.suffix))
-		//is file exist, remove it
-		if fileIsExist(tarFilename) {
-			os.Remove(tarFilename)
-		}
-		os.Rename(curFilename, tarFilename)
-		lg.logFile = openFile(lg.path, lg.filename)
-		lg.logger = log.New(lg.logFile, "", log.Ldate|log.Ltime)
 	}
+	if err := os.Rename(curFilename, tarFilename); err != nil {
+		log.Print("rotate log file error ", err.Error())
+		return false
+	}
+	logFile, err := createFile(lg.path, lg.filename)
+	if err != nil {
+		log.Print("open log file error ", err.Error())
+		os.Rename(tarFilename, curFilename)
+		return false
+	}
+	lg.logFile.Close()
+	lg.logFile = logFile
+	atomic.StoreInt64(&lg.size, fileSize(curFilename))
+	if lg.buf != nil {
+		lg.buf.Reset(lg.logFile)
+	}
+	return true
 }
 
 func fileIsExist(file string) bool {
@@ -220,28 +399,35 @@ func openFile(path, filename string) *os.File {
 	if !pathInfo.IsDir() {
 		Fatal("log path [" + path + "] is not a dir")
 	}
-	logFile, err := os.OpenFile(
-		path+"/"+filename,
-		os.O_RDWR|os.O_APPEND|os.O_CREATE,
-		0666)
+	logFile, err := createFile(path, filename)
 	if err != nil {
 		Fatal("open log file error", err.Error())
 	}
 	return logFile
 }
 
+func createFile(path, filename string) (*os.File, error) {
+	return os.OpenFile(
+		path+"/"+filename,
+		os.O_RDWR|os.O_APPEND|os.O_CREATE,
+		0666)
+}
+
 // callstack
 func getCallstack() []interface{} {
 	var callstack []interface{}
 	callstack = append(callstack, "Callstack:\n") // start with a new line
-	for skip := 0; ; skip++ {
-		_, file, line, ok := runtime.Caller(skip)
-		if !ok {
-			break
-		}
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(1, pcs)])
+	for {
+		frame, more := frames.Next()
 		// remove program and framework callstack
-		if !isFilterCallstack(file) {
-			callstack = append(callstack, file+":"+strconv.Itoa(line)+"\n")
+		if frame.File != "" && !isFilterCallstack(frame.File) {
+			callstack = append(callstack,
+				frame.File+":"+strconv.Itoa(frame.Line)+"\n")
+		}
+		if !more {
+			break
 		}
 	}
 	return callstack
@@ -254,7 +440,8 @@ func isFilterCallstack(file string) bool {
 			return true
 		}
 	*/
-	if strings.Contains(file, "/golang/src/") {
+	if strings.Contains(file, "/golang/src/") ||
+		filepath.Dir(file) == logDir {
 		return true
 	}
 	return false
@@ -0,0 +1,223 @@
+package log
+
+// 结构化日志
+//
+// 日志可以带有key/value字段，通过With创建带有字段的Entry
+// Entry.With可以在已有字段上继续添加字段，返回新的Entry
+// 文本格式中字段按名称排序附加在日志后面，json格式中字段与time，level等并列
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields 是结构化日志的字段
+type Fields map[string]interface{}
+
+// record 是一条日志
+type record struct {
+	time      time.Time
+	level     int
+	tag       string        // 输出日志时goroutine的标记
+	caller    string        // 调用日志方法的位置，只有json格式输出
+	msg       []interface{} // 日志内容
+	fields    Fields        // 日志字段
+	callstack []interface{} // 需要输出callstack时不为nil
+}
+
+// 各级别日志在文本格式中的前缀和在json格式中的名称
+var (
+	levelPrefixes = map[int]string{
+		DEBUG: "[DEBUG]", INFO: "[INFO]", WARN: "[WARN]", ERROR: "[ERROR]", FATAL: "[FATAL]"}
+	levelNames = map[int]string{
+		ALL: "callstack", DEBUG: "debug", INFO: "info", WARN: "warn", ERROR: "error", FATAL: "fatal"}
+)
+
+// logDir 是本包所在的目录，查找调用位置时跳过
+var logDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// newRecord 创建日志，调用位置和callstack由output按需设置
+func newRecord(level int, fields Fields, msg []interface{}) *record {
+	return &record{
+		time:   time.Now(),
+		level:  level,
+		tag:    Tag(),
+		msg:    msg,
+		fields: fields}
+}
+
+// caller 返回本包之外第一个调用位置，如coral/coral.go:120
+func caller() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if filepath.Dir(frame.File) != logDir {
+			return filepath.Base(filepath.Dir(frame.File)) + "/" +
+				filepath.Base(frame.File) + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// text 返回文本格式的日志，不包括时间
+func (rec *record) text() string {
+	msg := append(logInfo{withTag(levelPrefixes[rec.level], rec.tag)}, rec.msg...)
+	keys := make([]string, 0, len(rec.fields))
+	for key := range rec.fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		msg = append(msg, key+"="+textValue(rec.fields[key]))
+	}
+	return fmt.Sprintln(msg...)
+}
+
+// stackText 返回文本格式的callstack，不包括时间
+func (rec *record) stackText() string {
+	return fmt.Sprintln(append(logInfo{withTag("", rec.tag)}, rec.callstack...)...)
+}
+
+// textValue 返回字段值的文本，包含空格，等号或引号时加引号
+func textValue(value interface{}) string {
+	text := fmt.Sprint(value)
+	if text == "" || strings.ContainsAny(text, " =\"\n") {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+// line 返回写入文件的日志，文本格式带有时间，callstack跟随日志
+func (rec *record) line(format int) string {
+	if format == FORMAT_JSON {
+		return rec.json()
+	}
+	prefix := rec.time.Format("2006/01/02 15:04:05 ")
+	line := ""
+	if rec.level != ALL {
+		line = prefix + rec.text()
+	}
+	if rec.callstack != nil {
+		line = line + prefix + rec.stackText()
+	}
+	return line
+}
+
+// systemFields 是json格式中的系统字段，tag和callstack没有输出时也保留
+var systemFields = []string{"time", "level", "caller", "msg", "tag", "callstack"}
+
+// json 返回json格式的日志，以换行结尾
+// 字段与time，level等系统字段重名时，字段名加上"fields."前缀
+func (rec *record) json() string {
+	data := make(map[string]interface{}, len(rec.fields)+6)
+	for key, value := range rec.fields {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		data[key] = value
+	}
+	system := map[string]interface{}{
+		"time":   rec.time.Format("2006-01-02T15:04:05.000Z07:00"),
+		"level":  levelNames[rec.level],
+		"caller": rec.caller}
+	if rec.level != ALL {
+		system["msg"] = strings.TrimSuffix(fmt.Sprintln(rec.msg...), "\n")
+	}
+	if rec.tag != "" {
+		system["tag"] = rec.tag
+	}
+	if rec.callstack != nil {
+		var stack []string
+		for _, line := range rec.callstack[1:] {
+			stack = append(stack, strings.TrimSuffix(line.(string), "\n"))
+		}
+		system["callstack"] = stack
+	}
+	for _, key := range systemFields {
+		if field, ok := data[key]; ok {
+			delete(data, key)
+			data["fields."+key] = field
+		}
+		if value, ok := system[key]; ok {
+			data[key] = value
+		}
+	}
+	out, err := json.Marshal(data)
+	if err != nil {
+		// 有无法转换为json的字段时，按文本输出字段值
+		for key, value := range rec.fields {
+			if _, err := json.Marshal(value); err != nil {
+				data[key] = fmt.Sprint(value)
+			}
+		}
+		out, _ = json.Marshal(data)
+	}
+	return string(out) + "\n"
+}
+
+// Entry 是带有字段的logger，通过With创建
+type Entry struct {
+	logger *Logger // 为nil时输出到所有logger
+	fields Fields
+}
+
+// With 返回带有fields的Entry，日志输出到所有logger
+func With(fields Fields) *Entry {
+	return &Entry{fields: mergeFields(nil, fields)}
+}
+
+// With 返回带有fields的Entry，日志只输出到该logger
+func (lg *Logger) With(fields Fields) *Entry {
+	return &Entry{logger: lg, fields: mergeFields(nil, fields)}
+}
+
+// With 返回在当前字段上添加fields的Entry，当前Entry不变
+func (entry *Entry) With(fields Fields) *Entry {
+	return &Entry{logger: entry.logger, fields: mergeFields(entry.fields, fields)}
+}
+
+func mergeFields(fields, added Fields) Fields {
+	ret := make(Fields, len(fields)+len(added))
+	for key, value := range fields {
+		ret[key] = value
+	}
+	for key, value := range added {
+		ret[key] = value
+	}
+	return ret
+}
+
+func (entry *Entry) Debug(msg ...interface{}) {
+	output(entry.logger, DEBUG, entry.fields, true, msg)
+}
+
+func (entry *Entry) Info(msg ...interface{}) {
+	output(entry.logger, INFO, entry.fields, false, msg)
+}
+
+func (entry *Entry) Warn(msg ...interface{}) {
+	output(entry.logger, WARN, entry.fields, true, msg)
+}
+
+func (entry *Entry) Error(msg ...interface{}) {
+	output(entry.logger, ERROR, entry.fields, true, msg)
+}
+
+func (entry *Entry) Fatal(msg ...interface{}) {
+	output(entry.logger, FATAL, entry.fields, true, msg)
+	Flush()
+	os.Exit(1)
+}
@@ -0,0 +1,163 @@
+package log
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLogger 在临时目录中添加名为name的logger，返回目录和删除logger的函数
+// 删除时持有cleanMux不再释放，切分后启动的clean不会在目录删除后执行
+func testLogger(t *testing.T, name string) (*Logger, string, func()) {
+	dir, err := ioutil.TempDir("", "coral-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	AddLogger(name, dir, 3, 1<<20, FATAL, DEBUG)
+	logger := Log.Pool[name]
+	return logger, dir, func() {
+		logger.cleanMux.Lock()
+		delete(Log.Pool, name)
+		logger.logFile.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRecordJSON(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	tests := []struct {
+		name string
+		rec  *record
+		want map[string]interface{}
+	}{
+		{"fields", &record{time: now, level: INFO, caller: "coral/coral.go:1",
+			msg: []interface{}{"login", 1}, fields: Fields{"user": 1, "ok": true}},
+			map[string]interface{}{"time": "2024-01-02T03:04:05.006Z", "level": "info",
+				"caller": "coral/coral.go:1", "msg": "login 1", "user": 1.0, "ok": true}},
+		{"tag", &record{time: now, level: WARN, tag: "req-1", msg: []interface{}{"a"}},
+			map[string]interface{}{"time": "2024-01-02T03:04:05.006Z", "level": "warn",
+				"caller": "", "msg": "a", "tag": "req-1"}},
+		{"reserved names", &record{time: now, level: ERROR, msg: []interface{}{"a"},
+			fields: Fields{"msg": "m", "level": 1, "tag": "t"}},
+			map[string]interface{}{"time": "2024-01-02T03:04:05.006Z", "level": "error",
+				"caller": "", "msg": "a", "fields.msg": "m", "fields.level": 1.0,
+				"fields.tag": "t"}},
+		{"error value", &record{time: now, level: ERROR, msg: []interface{}{"a"},
+			fields: Fields{"err": errors.New("failed")}},
+			map[string]interface{}{"time": "2024-01-02T03:04:05.006Z", "level": "error",
+				"caller": "", "msg": "a", "err": "failed"}},
+		{"unmarshalable value", &record{time: now, level: INFO, msg: []interface{}{"a"},
+			fields: Fields{"f": func() {}, "n": 2}},
+			map[string]interface{}{"time": "2024-01-02T03:04:05.006Z", "level": "info",
+				"caller": "", "msg": "a", "n": 2.0}},
+		{"multiline msg", &record{time: now, level: INFO, msg: []interface{}{"a\nb"}},
+			map[string]interface{}{"time": "2024-01-02T03:04:05.006Z", "level": "info",
+				"caller": "", "msg": "a\nb"}},
+		{"callstack", &record{time: now, level: DEBUG, msg: []interface{}{"a"},
+			callstack: []interface{}{"Callstack:\n", "a.go:1\n", "b.go:2\n"}},
+			map[string]interface{}{"time": "2024-01-02T03:04:05.006Z", "level": "debug",
+				"caller": "", "msg": "a", "callstack": []interface{}{"a.go:1", "b.go:2"}}},
+		{"callstack only", &record{time: now, level: ALL,
+			callstack: []interface{}{"Callstack:\n", "a.go:1\n"}},
+			map[string]interface{}{"time": "2024-01-02T03:04:05.006Z", "level": "callstack",
+				"caller": "", "callstack": []interface{}{"a.go:1"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line := test.rec.line(FORMAT_JSON)
+			if !strings.HasSuffix(line, "\n") || strings.Count(line, "\n") != 1 {
+				t.Fatalf("line = %q, want a single line", line)
+			}
+			var data map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &data); err != nil {
+				t.Fatalf("invalid json %q: %v", line, err)
+			}
+			if test.name == "unmarshalable value" {
+				// 函数按文本输出，地址不固定
+				if f, ok := data["f"].(string); !ok || !strings.HasPrefix(f, "0x") {
+					t.Errorf("f = %v", data["f"])
+				}
+				delete(data, "f")
+			}
+			got, _ := json.Marshal(data)
+			want, _ := json.Marshal(test.want)
+			if string(got) != string(want) {
+				t.Errorf("json = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestRecordText(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)
+	tests := []struct {
+		name string
+		rec  *record
+		want string
+	}{
+		{"plain", &record{time: now, level: INFO, msg: []interface{}{"a", 1}},
+			"2024/01/02 03:04:05 [INFO] a 1\n"},
+		{"tag and fields", &record{time: now, level: WARN, tag: "req-1", msg: []interface{}{"a"},
+			fields: Fields{"b": "x y", "a": 1, "c": "", "d": `q"`}},
+			"2024/01/02 03:04:05 [WARN] [req-1] a a=1 b=\"x y\" c=\"\" d=\"q\\\"\"\n"},
+		{"callstack", &record{time: now, level: ERROR, tag: "req-1", msg: []interface{}{"a"},
+			callstack: []interface{}{"Callstack:\n", "a.go:1\n"}},
+			"2024/01/02 03:04:05 [ERROR] [req-1] a\n" +
+				"2024/01/02 03:04:05 [req-1] Callstack:\n a.go:1\n\n"},
+		{"callstack only", &record{time: now, level: ALL,
+			callstack: []interface{}{"Callstack:\n", "a.go:1\n"}},
+			"2024/01/02 03:04:05  Callstack:\n a.go:1\n\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if line := test.rec.line(FORMAT_TEXT); line != test.want {
+				t.Errorf("line = %q, want %q", line, test.want)
+			}
+		})
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	logger, dir, remove := testLogger(t, "json.log")
+	defer remove()
+	logger.SetFormat(FORMAT_JSON)
+
+	Bind("req-1")
+	logger.With(Fields{"user": 1}).With(Fields{"role": "admin"}).Info("login")
+	Unbind()
+	logger.Info("plain")
+
+	content, err := ioutil.ReadFile(dir + "/json.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("lines = %q", lines)
+	}
+	var first, second map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first["msg"] != "login" || first["user"] != 1.0 || first["role"] != "admin" ||
+		first["tag"] != "req-1" || first["level"] != "info" {
+		t.Errorf("first = %v", first)
+	}
+	// 调用位置跳过log包，测试中为testing包
+	if caller, _ := first["caller"].(string); !strings.HasPrefix(caller, "testing/") {
+		t.Errorf("caller = %q", caller)
+	}
+	if _, err := time.Parse("2006-01-02T15:04:05.000Z07:00", first["time"].(string)); err != nil {
+		t.Errorf("time = %v", first["time"])
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := second["tag"]; ok || second["msg"] != "plain" || len(second) != 4 {
+		t.Errorf("second = %v", second)
+	}
+}
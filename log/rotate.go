@@ -0,0 +1,215 @@
+package log
+
+// 按时间切分日志
+//
+// 默认按大小循环切分，文件名后缀为.0到.maxNumber-1
+// 指定Rotation后按天或按小时切分，切分后的文件名带有时间，如
+// coral.log.2026-10-19，coral.log.2026-10-19-15
+// 同一周期内文件超过maxSize时继续按大小切分，如coral.log.2026-10-19.1
+// 切分后在后台压缩文件，并删除超过保留时间或者总大小上限的文件
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 日志切分周期
+const (
+	ROTATE_DAILY = iota
+	ROTATE_HOURLY
+)
+
+// Rotation 是按时间切分日志的配置
+type Rotation struct {
+	Period   int           // ROTATE_DAILY或ROTATE_HOURLY
+	MaxAge   time.Duration // 切分后的文件保留时间，为0时不按时间删除
+	MaxTotal int64         // 切分后的文件总大小上限，超过时删除最旧的文件，为0时不限制
+	Compress bool          // 是否用gzip压缩切分后的文件
+}
+
+// layout 返回切分后文件名中的时间格式
+func (rotation *Rotation) layout() string {
+	if rotation.Period == ROTATE_HOURLY {
+		return "2006-01-02-15"
+	}
+	return "2006-01-02"
+}
+
+// truncate 返回t所在周期的开始时间
+func (rotation *Rotation) truncate(t time.Time) time.Time {
+	hour := 0
+	if rotation.Period == ROTATE_HOURLY {
+		hour = t.Hour()
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), hour, 0, 0, 0, t.Location())
+}
+
+// next 返回下一个周期的开始时间
+func (rotation *Rotation) next(start time.Time) time.Time {
+	if rotation.Period == ROTATE_HOURLY {
+		return start.Add(time.Hour)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// SetRotation 指定logger按时间切分，maxNumber不再生效
+// 已有的日志文件属于其修改时间所在的周期
+func (lg *Logger) SetRotation(rotation *Rotation) {
+	lg.mux.Lock()
+	defer lg.mux.Unlock()
+	lg.rotation = rotation
+	start := time.Now()
+	if info, err := os.Stat(lg.path + "/" + lg.filename); err == nil {
+		start = info.ModTime()
+	}
+	lg.periodStart = rotation.truncate(start)
+	lg.part = 0
+	go lg.clean()
+}
+
+// rotateByTime 在周期结束或者文件超过maxSize时切分日志
+func (lg *Logger) rotateByTime() {
+	curFilename := lg.path + "/" + lg.filename
+	now := time.Now()
+	lg.mux.RLock()
+	due := !now.Before(lg.rotation.next(lg.periodStart))
+	lg.mux.RUnlock()
+	if !due && (lg.maxSize <= 0 || atomic.LoadInt64(&lg.size) <= lg.maxSize) {
+		return
+	}
+
+	lg.mux.Lock()
+	defer lg.mux.Unlock()
+	// 等待锁的过程中可能已经切分过了
+	due = !now.Before(lg.rotation.next(lg.periodStart))
+	if !due && (lg.maxSize <= 0 || atomic.LoadInt64(&lg.size) <= lg.maxSize) {
+		return
+	}
+	prefix := curFilename + "." + lg.periodStart.Format(lg.rotation.layout())
+	tarFilename := prefix
+	if !due || lg.part > 0 {
+		lg.part++
+		tarFilename = prefix + "." + strconv.Itoa(lg.part)
+	}
+	for fileIsExist(tarFilename) || fileIsExist(tarFilename+".gz") {
+		lg.part++
+		tarFilename = prefix + "." + strconv.Itoa(lg.part)
+	}
+	if !lg.switchFile(tarFilename) {
+		return
+	}
+	if due {
+		lg.periodStart = lg.rotation.truncate(now)
+		lg.part = 0
+	}
+	go lg.clean()
+}
+
+// rotatedFile 是一个已切分的日志文件
+type rotatedFile struct {
+	name string
+	info os.FileInfo
+}
+
+// rotatedFiles 返回已切分的日志文件，按修改时间从新到旧排序
+func (lg *Logger) rotatedFiles() []*rotatedFile {
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(lg.filename) +
+		`\.\d{4}-\d{2}-\d{2}(-\d{2})?(\.\d+)?(\.gz)?$`)
+	dir, err := os.Open(lg.path)
+	if err != nil {
+		Error("read log path error", err.Error())
+		return nil
+	}
+	infos, err := dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		Error("read log path error", err.Error())
+		return nil
+	}
+	var files []*rotatedFile
+	for _, info := range infos {
+		if !info.IsDir() && pattern.MatchString(info.Name()) {
+			files = append(files, &rotatedFile{lg.path + "/" + info.Name(), info})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().After(files[j].info.ModTime())
+	})
+	return files
+}
+
+// clean 压缩已切分的文件，删除超过保留时间和总大小上限的文件
+// 在后台执行，同一个logger同时只有一个clean在执行
+func (lg *Logger) clean() {
+	lg.cleanMux.Lock()
+	defer lg.cleanMux.Unlock()
+	lg.mux.RLock()
+	rotation := *lg.rotation
+	lg.mux.RUnlock()
+
+	files := lg.rotatedFiles()
+	if rotation.Compress {
+		for _, file := range files {
+			if strings.HasSuffix(file.name, ".gz") {
+				continue
+			}
+			if err := gzipFile(file.name, file.info); err != nil {
+				Error("compress log error", file.name, err.Error())
+			}
+		}
+		files = lg.rotatedFiles()
+	}
+	var total int64
+	for _, file := range files {
+		expired := rotation.MaxAge > 0 &&
+			time.Now().Sub(file.info.ModTime()) > rotation.MaxAge
+		if !expired {
+			total += file.info.Size()
+		}
+		if expired || (rotation.MaxTotal > 0 && total > rotation.MaxTotal) {
+			if err := os.Remove(file.name); err != nil {
+				Error("remove log error", file.name, err.Error())
+			}
+		}
+	}
+}
+
+// gzipFile 压缩文件为.gz并删除原文件，压缩后的文件保留原文件的修改时间
+func gzipFile(name string, info os.FileInfo) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := name + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(name)
+}
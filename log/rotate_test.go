@@ -0,0 +1,202 @@
+package log
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logFiles 返回目录中的文件名和内容
+func logFiles(t *testing.T, dir string) map[string]string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, info := range infos {
+		content, err := ioutil.ReadFile(dir + "/" + info.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[info.Name()] = string(content)
+	}
+	return files
+}
+
+func TestRotationPeriod(t *testing.T) {
+	now := time.Date(2026, 10, 19, 15, 4, 5, 0, time.Local)
+	tests := []struct {
+		period int
+		name   string
+		start  time.Time
+		next   time.Time
+	}{
+		{ROTATE_DAILY, "coral.log.2026-10-19",
+			time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local),
+			time.Date(2026, 10, 20, 0, 0, 0, 0, time.Local)},
+		{ROTATE_HOURLY, "coral.log.2026-10-19-15",
+			time.Date(2026, 10, 19, 15, 0, 0, 0, time.Local),
+			time.Date(2026, 10, 19, 16, 0, 0, 0, time.Local)},
+	}
+	for _, test := range tests {
+		rotation := &Rotation{Period: test.period}
+		start := rotation.truncate(now)
+		if !start.Equal(test.start) || !rotation.next(start).Equal(test.next) {
+			t.Errorf("period %d: start %v, next %v", test.period, start, rotation.next(start))
+		}
+		if name := "coral.log." + start.Format(rotation.layout()); name != test.name {
+			t.Errorf("period %d: name = %q, want %q", test.period, name, test.name)
+		}
+	}
+}
+
+func TestRotateByTime(t *testing.T) {
+	logger, dir, remove := testLogger(t, "rotate.log")
+	defer remove()
+	logger.SetRotation(&Rotation{Period: ROTATE_DAILY})
+	today := time.Now().Format("2006-01-02")
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	setPeriod := func(start time.Time, part int) {
+		logger.mux.Lock()
+		logger.periodStart = logger.rotation.truncate(start)
+		logger.part = part
+		logger.mux.Unlock()
+	}
+
+	// 同一周期内不切分
+	logger.Raw("a")
+	// 周期结束时切分为带日期的文件
+	setPeriod(time.Now().AddDate(0, 0, -1), 0)
+	logger.Raw("b")
+	// 超过maxSize时在当前周期内按序号切分
+	logger.maxSize = 1
+	logger.Raw("c")
+	logger.Raw("d")
+	// 周期内按大小切分过时，周期结束的文件继续使用序号
+	setPeriod(time.Now().AddDate(0, 0, -1), 2)
+	logger.maxSize = 1 << 20
+	logger.Raw("e")
+
+	want := map[string]string{
+		"rotate.log":                     "e\n",
+		"rotate.log." + yesterday:        "a\n",
+		"rotate.log." + today + ".1":     "b\n",
+		"rotate.log." + today + ".2":     "c\n",
+		"rotate.log." + yesterday + ".3": "d\n",
+	}
+	files := logFiles(t, dir)
+	if len(files) != len(want) {
+		t.Errorf("files = %v", files)
+	}
+	for name, content := range want {
+		if files[name] != content {
+			t.Errorf("%s = %q, want %q", name, files[name], content)
+		}
+	}
+
+	// 目标文件已存在时使用下一个序号，不覆盖
+	setPeriod(time.Now().AddDate(0, 0, -2), 0)
+	twoDaysAgo := time.Now().AddDate(0, 0, -2).Format("2006-01-02")
+	ioutil.WriteFile(dir+"/rotate.log."+twoDaysAgo, []byte("old\n"), 0644)
+	ioutil.WriteFile(dir+"/rotate.log."+twoDaysAgo+".1.gz", []byte("old\n"), 0644)
+	logger.Raw("f")
+	files = logFiles(t, dir)
+	if files["rotate.log."+twoDaysAgo] != "old\n" ||
+		files["rotate.log."+twoDaysAgo+".2"] != "e\n" || files["rotate.log"] != "f\n" {
+		t.Errorf("files = %v", files)
+	}
+}
+
+func TestRotateClean(t *testing.T) {
+	logger, dir, remove := testLogger(t, "clean.log")
+	defer remove()
+	now := time.Now()
+	files := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"clean.log.2026-10-19", 10, time.Hour},
+		{"clean.log.2026-10-18.1", 10, 24 * time.Hour},
+		{"clean.log.2026-10-18-23.gz", 10, 25 * time.Hour},
+		{"clean.log.2026-10-10", 10, 10 * 24 * time.Hour},
+		{"clean.log.bak", 10, 10 * 24 * time.Hour},
+		{"other.log.2026-10-10", 10, 10 * 24 * time.Hour},
+	}
+	for _, file := range files {
+		name := dir + "/" + file.name
+		ioutil.WriteFile(name, []byte(strings.Repeat("x", file.size)), 0644)
+		os.Chtimes(name, now.Add(-file.age), now.Add(-file.age))
+	}
+
+	tests := []struct {
+		name     string
+		rotation Rotation
+		removed  []string
+	}{
+		{"no limit", Rotation{}, nil},
+		{"max age", Rotation{MaxAge: 48 * time.Hour}, []string{"clean.log.2026-10-10"}},
+		{"max total", Rotation{MaxTotal: 25},
+			[]string{"clean.log.2026-10-10", "clean.log.2026-10-18-23.gz"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rotation := test.rotation
+			logger.mux.Lock()
+			logger.rotation = &rotation
+			logger.mux.Unlock()
+			logger.clean()
+			var removed []string
+			for _, file := range files {
+				if _, err := os.Stat(dir + "/" + file.name); os.IsNotExist(err) {
+					removed = append(removed, file.name)
+				}
+			}
+			sort.Strings(removed)
+			if strings.Join(removed, ",") != strings.Join(test.removed, ",") {
+				t.Errorf("removed = %v, want %v", removed, test.removed)
+			}
+		})
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	logger, dir, remove := testLogger(t, "gz.log")
+	defer remove()
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	name := dir + "/gz.log.2026-10-19"
+	ioutil.WriteFile(name, []byte("rotated\n"), 0644)
+	os.Chtimes(name, modTime, modTime)
+
+	logger.mux.Lock()
+	logger.rotation = &Rotation{Compress: true}
+	logger.mux.Unlock()
+	logger.clean()
+
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("uncompressed file not removed")
+	}
+	file, err := os.Open(name + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, _ := file.Stat()
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("gz mod time = %v, want %v", info.ModTime(), modTime)
+	}
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadAll(zr); string(content) != "rotated\n" {
+		t.Errorf("content = %q", content)
+	}
+	if files := logFiles(t, dir); len(files) != 2 {
+		t.Errorf("files = %v", files)
+	}
+}
@@ -0,0 +1,103 @@
+package log
+
+// 日志标记，用于关联同一个请求中输出的所有日志
+//
+// 标记与goroutine绑定，在请求开始时Bind，结束时Unbind
+// 期间在同一个goroutine中输出的所有日志都会带上[tag]
+// 新启动的goroutine不会继承标记，需要的话自行Bind
+//
+// 标记保存在goroutine本地数据中，trace的当前span也使用同一份数据
+// goroutine通过GoroutineID识别，没有任何goroutine保存数据时不解析runtime.Stack
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// tagKey 是日志标记在goroutine本地数据中的key
+const tagKey = "log.tag"
+
+var (
+	localMux   = new(sync.RWMutex)
+	locals     = make(map[int64]map[string]interface{}) // goroutine id -> 本地数据
+	localCount int64                                    // 保存了本地数据的goroutine数，为0时不需要取goroutine id
+)
+
+// SetLocal 为id对应的goroutine保存一个值，value为nil时删除
+// 保存的值在goroutine结束后不会自动删除，必须在结束前删除，否则id复用时会取到旧值
+func SetLocal(id int64, key string, value interface{}) {
+	localMux.Lock()
+	defer localMux.Unlock()
+	values := locals[id]
+	if value == nil {
+		delete(values, key)
+		if values != nil && len(values) == 0 {
+			delete(locals, id)
+			atomic.AddInt64(&localCount, -1)
+		}
+		return
+	}
+	if values == nil {
+		values = make(map[string]interface{})
+		locals[id] = values
+		atomic.AddInt64(&localCount, 1)
+	}
+	values[key] = value
+}
+
+// GetLocal 返回id对应的goroutine保存的值
+func GetLocal(id int64, key string) interface{} {
+	localMux.RLock()
+	defer localMux.RUnlock()
+	return locals[id][key]
+}
+
+// HasLocal 返回是否有goroutine保存了本地数据，没有时不需要取goroutine id
+func HasLocal() bool {
+	return atomic.LoadInt64(&localCount) > 0
+}
+
+// Bind 为当前goroutine绑定日志标记
+func Bind(tag string) {
+	SetLocal(GoroutineID(), tagKey, tag)
+}
+
+// Unbind 解除当前goroutine绑定的日志标记
+func Unbind() {
+	SetLocal(GoroutineID(), tagKey, nil)
+}
+
+// Tag 返回当前goroutine绑定的日志标记
+func Tag() string {
+	if !HasLocal() {
+		return ""
+	}
+	tag, _ := GetLocal(GoroutineID(), tagKey).(string)
+	return tag
+}
+
+// GoroutineID 返回当前goroutine的id，从runtime.Stack的第一行"goroutine 123 [running]:"中取出id
+func GoroutineID() int64 {
+	var buf [64]byte
+	stack := buf[:runtime.Stack(buf[:], false)]
+	stack = bytes.TrimPrefix(stack, []byte("goroutine "))
+	if i := bytes.IndexByte(stack, ' '); i > 0 {
+		stack = stack[:i]
+	}
+	id, _ := strconv.ParseInt(string(stack), 10, 64)
+	return id
+}
+
+// withTag 在日志前缀后加上日志标记
+func withTag(prefix, tag string) string {
+	if tag != "" {
+		if prefix == "" {
+			return "[" + tag + "]"
+		}
+		return prefix + " [" + tag + "]"
+	}
+	return prefix
+}
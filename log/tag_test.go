@@ -0,0 +1,58 @@
+package log
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestTag(t *testing.T) {
+	if tag := Tag(); tag != "" {
+		t.Fatalf("Tag() = %q before Bind", tag)
+	}
+	Bind("req-1")
+	if tag := Tag(); tag != "req-1" {
+		t.Errorf("Tag() = %q, want req-1", tag)
+	}
+
+	// 其他goroutine不会继承标记
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if tag := Tag(); tag != "" {
+			t.Errorf("Tag() in new goroutine = %q", tag)
+		}
+		Bind("req-2")
+		defer Unbind()
+		if tag := Tag(); tag != "req-2" {
+			t.Errorf("Tag() in new goroutine = %q, want req-2", tag)
+		}
+	}()
+	wg.Wait()
+
+	Unbind()
+	if tag := Tag(); tag != "" || HasLocal() {
+		t.Errorf("after Unbind Tag() = %q, HasLocal() = %v", tag, HasLocal())
+	}
+}
+
+func TestLocal(t *testing.T) {
+	id := GoroutineID()
+	if id <= 0 {
+		t.Fatalf("GoroutineID() = %d", id)
+	}
+	SetLocal(id, "a", 1)
+	SetLocal(id, "b", "x")
+	if GetLocal(id, "a") != 1 || GetLocal(id, "b") != "x" || GetLocal(id+1, "a") != nil {
+		t.Errorf("GetLocal returned wrong values")
+	}
+	SetLocal(id, "a", nil)
+	if !HasLocal() {
+		t.Errorf("HasLocal() = false with b still set")
+	}
+	SetLocal(id, "b", nil)
+	SetLocal(id, "missing", nil)
+	if HasLocal() {
+		t.Errorf("HasLocal() = true after all values deleted")
+	}
+}
@@ -0,0 +1,302 @@
+package coral
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coral/cache"
+	"github.com/coral/db"
+	. "github.com/coral/log"
+)
+
+// DefaultMetricsPath 是管理server输出统计数据的路由
+const DefaultMetricsPath = "/metrics"
+
+// MetricsBuckets 是耗时直方图默认的桶，单位秒
+// EnableMetrics时复制一份，之后修改不影响已经开启的统计
+var MetricsBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram 是一个直方图，counts[i]为不超过buckets[i]的次数
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(buckets []float64, seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+	for i, bucket := range buckets {
+		if seconds <= bucket {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// metrics 记录server的请求统计，输出为prometheus文本格式
+type metrics struct {
+	mux      *sync.Mutex
+	buckets  []float64 // 直方图的桶，开启时从MetricsBuckets复制
+	inFlight int64
+	requests map[[3]string]uint64     // path, http状态码, status
+	latency  map[string]*histogram    // path
+	filters  map[[2]string]*histogram // path, filter
+}
+
+// newMetrics 创建统计，buckets会被复制并排序
+func newMetrics(buckets []float64) *metrics {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &metrics{
+		mux:      new(sync.Mutex),
+		buckets:  buckets,
+		requests: make(map[[3]string]uint64),
+		latency:  make(map[string]*histogram),
+		filters:  make(map[[2]string]*histogram)}
+}
+
+// EnableMetrics 开启请求统计，buckets为耗时直方图的桶，单位秒，为空时使用MetricsBuckets
+// 统计数据只通过NewAdminServer创建的管理server的/metrics输出，不会注册在server上
+func (server *Server) EnableMetrics(buckets ...float64) {
+	if len(buckets) < 1 {
+		buckets = MetricsBuckets
+	}
+	server.metrics = newMetrics(buckets)
+}
+
+// serveMetrics 输出server的统计数据，未开启统计时返回404
+func (server *Server) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	if server.metrics == nil {
+		http.Error(w, "metrics not enabled", http.StatusNotFound)
+		return
+	}
+	server.metrics.serve(w, req)
+}
+
+// metrics 返回router所在server的统计，未开启时返回nil
+func (router *Router) metrics() *metrics {
+	if router.server == nil {
+		return nil
+	}
+	return router.server.metrics
+}
+
+// observeRequest 记录一个请求
+func (m *metrics) observeRequest(context *Context) {
+	status := ""
+	if !context.Raw {
+		status = strconv.Itoa(context.Status)
+	}
+	seconds := time.Now().Sub(context.startTime).Seconds()
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.requests[[3]string{context.Path, strconv.Itoa(context.w.status), status}]++
+	h, ok := m.latency[context.Path]
+	if !ok {
+		h = &histogram{}
+		m.latency[context.Path] = h
+	}
+	h.observe(m.buckets, seconds)
+}
+
+// observeFilter 记录一个filter的耗时
+func (m *metrics) observeFilter(path, filter string, duration time.Duration) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	key := [2]string{path, filter}
+	h, ok := m.filters[key]
+	if !ok {
+		h = &histogram{}
+		m.filters[key] = h
+	}
+	h.observe(m.buckets, duration.Seconds())
+}
+
+// filterName 返回filter的函数名
+func filterName(filter Filter) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(filter).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	return fn.Name()
+}
+
+// serve 输出所有统计数据
+func (m *metrics) serve(w http.ResponseWriter, req *http.Request) {
+	buf := &bytes.Buffer{}
+	m.writeRequests(buf)
+	writePoolMetrics(buf)
+
+	writeHelp(buf, "coral_log_write_errors_total", "counter",
+		"Number of failed log writes.")
+	fmt.Fprintf(buf, "coral_log_write_errors_total %d\n", WriteErrors())
+	writeHelp(buf, "coral_log_dropped_total", "counter",
+		"Number of log lines dropped because the async queue was full.")
+	fmt.Fprintf(buf, "coral_log_dropped_total %d\n", Dropped())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (m *metrics) writeRequests(buf *bytes.Buffer) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	writeHelp(buf, "coral_requests_in_flight", "gauge",
+		"Number of requests being served.")
+	fmt.Fprintf(buf, "coral_requests_in_flight %d\n",
+		atomic.LoadInt64(&m.inFlight))
+
+	writeHelp(buf, "coral_requests_total", "counter",
+		"Number of requests by route, http status and coral status.")
+	requestKeys := make([][3]string, 0, len(m.requests))
+	for key := range m.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		return strings.Join(requestKeys[i][:], "\x00") <
+			strings.Join(requestKeys[j][:], "\x00")
+	})
+	for _, key := range requestKeys {
+		fmt.Fprintf(buf, "coral_requests_total{path=%s,code=%s,status=%s} %d\n",
+			quote(key[0]), quote(key[1]), quote(key[2]), m.requests[key])
+	}
+
+	writeHelp(buf, "coral_request_duration_seconds", "histogram",
+		"Request latency by route.")
+	paths := make([]string, 0, len(m.latency))
+	for path := range m.latency {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		writeHistogram(buf, "coral_request_duration_seconds",
+			"path="+quote(path), m.buckets, m.latency[path])
+	}
+
+	writeHelp(buf, "coral_filter_duration_seconds", "histogram",
+		"Filter latency by route and filter.")
+	filterKeys := make([][2]string, 0, len(m.filters))
+	for key := range m.filters {
+		filterKeys = append(filterKeys, key)
+	}
+	sort.Slice(filterKeys, func(i, j int) bool {
+		if filterKeys[i][0] != filterKeys[j][0] {
+			return filterKeys[i][0] < filterKeys[j][0]
+		}
+		return filterKeys[i][1] < filterKeys[j][1]
+	})
+	for _, key := range filterKeys {
+		writeHistogram(buf, "coral_filter_duration_seconds",
+			"path="+quote(key[0])+",filter="+quote(key[1]), m.buckets, m.filters[key])
+	}
+}
+
+// writePoolMetrics 输出db和redis连接池的统计
+func writePoolMetrics(buf *bytes.Buffer) {
+	dbNames := make([]string, 0, len(db.DB.Pool))
+	for name := range db.DB.Pool {
+		dbNames = append(dbNames, name)
+	}
+	sort.Strings(dbNames)
+	dbStats := make(map[string]sql.DBStats, len(dbNames))
+	for _, name := range dbNames {
+		dbStats[name] = db.DB.Pool[name].Stats()
+	}
+	writeHelp(buf, "coral_db_open_connections", "gauge",
+		"Number of established db connections.")
+	for _, name := range dbNames {
+		stats := dbStats[name]
+		fmt.Fprintf(buf, "coral_db_open_connections{db=%s} %d\n",
+			quote(name), stats.OpenConnections)
+	}
+	writeHelp(buf, "coral_db_in_use_connections", "gauge",
+		"Number of db connections in use.")
+	for _, name := range dbNames {
+		stats := dbStats[name]
+		fmt.Fprintf(buf, "coral_db_in_use_connections{db=%s} %d\n",
+			quote(name), stats.InUse)
+	}
+	writeHelp(buf, "coral_db_idle_connections", "gauge",
+		"Number of idle db connections.")
+	for _, name := range dbNames {
+		stats := dbStats[name]
+		fmt.Fprintf(buf, "coral_db_idle_connections{db=%s} %d\n",
+			quote(name), stats.Idle)
+	}
+	writeHelp(buf, "coral_db_wait_total", "counter",
+		"Number of times waited for a db connection.")
+	for _, name := range dbNames {
+		stats := dbStats[name]
+		fmt.Fprintf(buf, "coral_db_wait_total{db=%s} %d\n",
+			quote(name), stats.WaitCount)
+	}
+	writeHelp(buf, "coral_db_wait_seconds_total", "counter",
+		"Total time waited for a db connection.")
+	for _, name := range dbNames {
+		stats := dbStats[name]
+		fmt.Fprintf(buf, "coral_db_wait_seconds_total{db=%s} %s\n",
+			quote(name), formatFloat(stats.WaitDuration.Seconds()))
+	}
+
+	redisNames := make([]string, 0, len(cache.Cache.Pool))
+	for name := range cache.Cache.Pool {
+		redisNames = append(redisNames, name)
+	}
+	sort.Strings(redisNames)
+	writeHelp(buf, "coral_redis_active_connections", "gauge",
+		"Number of redis connections, including idle ones.")
+	for _, name := range redisNames {
+		active, _ := cache.Stats(name)
+		fmt.Fprintf(buf, "coral_redis_active_connections{redis=%s} %d\n",
+			quote(name), active)
+	}
+	writeHelp(buf, "coral_redis_idle_connections", "gauge",
+		"Number of idle redis connections.")
+	for _, name := range redisNames {
+		_, idle := cache.Stats(name)
+		fmt.Fprintf(buf, "coral_redis_idle_connections{redis=%s} %d\n",
+			quote(name), idle)
+	}
+}
+
+func writeHelp(buf *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(buf *bytes.Buffer, name, labels string, buckets []float64,
+	h *histogram) {
+	for i, bucket := range buckets {
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"%s\"} %d\n",
+			name, labels, formatFloat(bucket), h.counts[i])
+	}
+	fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// quote 按prometheus的规则转义标签值
+func quote(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return `"` + value + `"`
+}
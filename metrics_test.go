@@ -0,0 +1,69 @@
+package coral
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	saved := MetricsBuckets
+	defer func() { MetricsBuckets = saved }()
+	MetricsBuckets = []float64{1, 0.1}
+
+	server := testServer(func(server *Server) {
+		server.EnableMetrics()
+		server.NewRouter("/ok", func(context *Context) bool {
+			return true
+		})
+	})
+	// 开启后修改MetricsBuckets不影响已经开启的统计
+	MetricsBuckets = []float64{0.5}
+	serveTest(server, testRequest("GET", "/ok", nil))
+	serveTest(server, testRequest("GET", "/ok", nil))
+
+	if resp := serveTest(server, testRequest("GET", DefaultMetricsPath, nil)); resp.Code == 200 &&
+		strings.Contains(resp.Body.String(), "coral_requests_total") {
+		t.Errorf("metrics exposed on the public server")
+	}
+
+	admin := NewAdminServer("", server)
+	resp := serveTest(admin, testRequest("GET", DefaultMetricsPath, nil))
+	body := resp.Body.String()
+	for _, line := range []string{
+		`coral_requests_total{path="/ok",code="200",status="0"} 2`,
+		`coral_request_duration_seconds_bucket{path="/ok",le="0.1"} 2`,
+		`coral_request_duration_seconds_bucket{path="/ok",le="1"} 2`,
+		`coral_request_duration_seconds_bucket{path="/ok",le="+Inf"} 2`,
+		`coral_request_duration_seconds_count{path="/ok"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics missing %q", line)
+		}
+	}
+	if strings.Contains(body, `le="0.5"`) {
+		t.Errorf("metrics use buckets changed after EnableMetrics")
+	}
+
+	disabled := NewAdminServer("", testServer(func(server *Server) {}))
+	if code := serveTest(disabled, testRequest("GET", DefaultMetricsPath, nil)).Code; code != 404 {
+		t.Errorf("metrics without EnableMetrics = %d, want 404", code)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	m := newMetrics([]float64{1, 0.1, 0.5})
+	h := &histogram{}
+	for _, seconds := range []float64{0.05, 0.3, 0.7, 2} {
+		h.observe(m.buckets, seconds)
+	}
+	want := []uint64{1, 2, 3}
+	for i := range want {
+		if h.counts[i] != want[i] {
+			t.Errorf("counts = %v, want %v", h.counts, want)
+			break
+		}
+	}
+	if h.count != 4 || h.sum != 3.05 {
+		t.Errorf("count = %d, sum = %v", h.count, h.sum)
+	}
+}
@@ -0,0 +1,118 @@
+package coral
+
+// 管理server的pprof，直接使用runtime/pprof实现
+//
+// 注意：不要引入net/http/pprof，它在init中会向http.DefaultServeMux注册/debug/pprof/
+// 使用DefaultServeMux对外服务的程序引入coral后会在不知情的情况下暴露pprof
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	rtrace "runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registerPprof 在mux上注册pprof，go tool pprof可以直接使用
+func registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprofIndex)
+	mux.HandleFunc("/debug/pprof/cmdline", pprofCmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprofProfile)
+	mux.HandleFunc("/debug/pprof/trace", pprofTrace)
+}
+
+// pprofIndex 列出所有profile，/debug/pprof/heap等输出对应的profile
+func pprofIndex(w http.ResponseWriter, req *http.Request) {
+	if name := strings.TrimPrefix(req.URL.Path, "/debug/pprof/"); name != "" {
+		pprofLookup(w, req, name)
+		return
+	}
+	profiles := pprof.Profiles()
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name() < profiles[j].Name()
+	})
+	ret := "<!doctype html><pre>\n"
+	for _, profile := range profiles {
+		name := html.EscapeString(profile.Name())
+		ret = ret + fmt.Sprintf("%d\t<a href='%s?debug=1'>%s</a>\n",
+			profile.Count(), name, name)
+	}
+	ret = ret + "<a href='profile?seconds=30'>profile</a>\n" +
+		"<a href='trace?seconds=1'>trace</a>\n" +
+		"<a href='cmdline'>cmdline</a>\n</pre>\n"
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(ret))
+}
+
+// pprofLookup 输出指定的profile，debug不为0时输出文本格式
+func pprofLookup(w http.ResponseWriter, req *http.Request, name string) {
+	profile := pprof.Lookup(name)
+	if profile == nil {
+		http.Error(w, "unknown profile: "+name, http.StatusNotFound)
+		return
+	}
+	if name == "heap" && req.FormValue("gc") != "" {
+		runtime.GC()
+	}
+	debug, _ := strconv.Atoi(req.FormValue("debug"))
+	if debug != 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	}
+	profile.WriteTo(w, debug)
+}
+
+func pprofCmdline(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(strings.Join(os.Args, "\x00")))
+}
+
+// pprofProfile 采集seconds秒的cpu profile，默认30秒
+func pprofProfile(w http.ResponseWriter, req *http.Request) {
+	duration := pprofSeconds(req, 30)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := pprof.StartCPUProfile(w); err != nil {
+		http.Error(w, "could not enable cpu profiling: "+err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+	pprofSleep(req, duration)
+	pprof.StopCPUProfile()
+}
+
+// pprofTrace 采集seconds秒的执行trace，默认1秒
+func pprofTrace(w http.ResponseWriter, req *http.Request) {
+	duration := pprofSeconds(req, 1)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := rtrace.Start(w); err != nil {
+		http.Error(w, "could not enable tracing: "+err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+	pprofSleep(req, duration)
+	rtrace.Stop()
+}
+
+func pprofSeconds(req *http.Request, def float64) time.Duration {
+	seconds, err := strconv.ParseFloat(req.FormValue("seconds"), 64)
+	if err != nil || seconds <= 0 {
+		seconds = def
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// pprofSleep 等待duration，客户端断开时提前返回
+func pprofSleep(req *http.Request, duration time.Duration) {
+	select {
+	case <-time.After(duration):
+	case <-req.Context().Done():
+	}
+}
@@ -0,0 +1,265 @@
+package coral
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coral/cache"
+	. "github.com/coral/log"
+)
+
+// 限流算法
+const (
+	TOKEN_BUCKET   = iota // 令牌桶，每Window补充Limit个令牌，允许Limit个突发请求
+	SLIDING_WINDOW        // 滑动窗口，任意Window时间内最多Limit个请求
+)
+
+// LimitStore 保存限流状态，返回是否允许请求，不允许时返回需要等待的时间
+type LimitStore interface {
+	TokenBucket(key string, limit int, window time.Duration) (bool, time.Duration)
+	SlidingWindow(key string, limit int, window time.Duration) (bool, time.Duration)
+}
+
+// Limiter 是一个限流器，通过Filter作为路由的filter使用
+type Limiter struct {
+	Algorithm int                   // 限流算法
+	Limit     int                   // Window时间内允许的请求数
+	Window    time.Duration         // 限流的时间窗口
+	Status    int                   // 被限流时返回的status，默认STATUS_TOO_MANY_REQUESTS
+	Prefix    string                // 限流key的前缀，多个Limiter共用store时区分
+	Key       func(*Context) string // 限流的维度，默认按客户端ip
+
+	store LimitStore
+}
+
+// NewLimiter 创建一个按客户端ip限流的限流器
+// 如每个ip每分钟最多5次：NewLimiter(store, SLIDING_WINDOW, 5, time.Minute)
+func NewLimiter(store LimitStore, algorithm, limit int,
+	window time.Duration) *Limiter {
+	return &Limiter{
+		Algorithm: algorithm,
+		Limit:     limit,
+		Window:    window,
+		Status:    STATUS_TOO_MANY_REQUESTS,
+		Key:       LimitByIP,
+		store:     store}
+}
+
+// LimitByIP 按客户端ip限流
+func LimitByIP(context *Context) string {
+	return "ip:" + context.ClientIP()
+}
+
+// LimitByParam 按参数限流，如手机号，用户id
+// 参数为空时按客户端ip限流
+func LimitByParam(name string) func(*Context) string {
+	return func(context *Context) string {
+		value := String(context.Params[name])
+		if value == "" {
+			return LimitByIP(context)
+		}
+		return name + ":" + value
+	}
+}
+
+// Filter 检查请求是否超过限制，超过时返回Status并设置Retry-After响应头
+// store出错时不限流
+func (limiter *Limiter) Filter(context *Context) bool {
+	key := limiter.Prefix + context.Path + ":" + limiter.Key(context)
+	var ok bool
+	var wait time.Duration
+	switch limiter.Algorithm {
+	case SLIDING_WINDOW:
+		ok, wait = limiter.store.SlidingWindow(key, limiter.Limit, limiter.Window)
+	default:
+		ok, wait = limiter.store.TokenBucket(key, limiter.Limit, limiter.Window)
+	}
+	if ok {
+		return true
+	}
+	Debug("request limited", key, wait)
+	context.SetHeader("Retry-After",
+		strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	context.Status = limiter.Status
+	return false
+}
+
+// memoryLimitStore 是单实例使用的内存限流store
+type memoryLimitStore struct {
+	mux     *sync.Mutex
+	buckets map[string]*tokenBucket
+	windows map[string]*slidingWindow
+	sweep   time.Time // 上次清理过期状态的时间
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // 令牌补满的时间，之后可以清理
+}
+
+type slidingWindow struct {
+	times  []time.Time // 窗口内的请求时间
+	window time.Duration
+}
+
+// memoryLimitSweep 是内存store清理过期状态的间隔
+const memoryLimitSweep = time.Minute
+
+// NewMemoryLimitStore 创建内存限流store，多实例部署时各实例分别计数
+func NewMemoryLimitStore() LimitStore {
+	return &memoryLimitStore{
+		mux:     new(sync.Mutex),
+		buckets: make(map[string]*tokenBucket),
+		windows: make(map[string]*slidingWindow),
+		sweep:   time.Now()}
+}
+
+func (store *memoryLimitStore) TokenBucket(
+	key string, limit int, window time.Duration) (bool, time.Duration) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	now := time.Now()
+	store.clean(now)
+	rate := float64(limit) / float64(window) // 每纳秒补充的令牌
+	bucket, ok := store.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit), last: now}
+		store.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(limit),
+		bucket.tokens+float64(now.Sub(bucket.last))*rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.full = now.Add(time.Duration((float64(limit) - bucket.tokens) / rate))
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / rate)
+}
+
+func (store *memoryLimitStore) SlidingWindow(
+	key string, limit int, window time.Duration) (bool, time.Duration) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	now := time.Now()
+	store.clean(now)
+	sw, ok := store.windows[key]
+	if !ok {
+		sw = &slidingWindow{}
+		store.windows[key] = sw
+	}
+	sw.window = window
+	i := 0
+	for i < len(sw.times) && now.Sub(sw.times[i]) >= window {
+		i++
+	}
+	sw.times = sw.times[i:]
+	if len(sw.times) < limit {
+		sw.times = append(sw.times, now)
+		return true, 0
+	}
+	return false, sw.times[0].Add(window).Sub(now)
+}
+
+// clean 定期清理已经补满的令牌桶和已经过期的窗口
+func (store *memoryLimitStore) clean(now time.Time) {
+	if now.Sub(store.sweep) < memoryLimitSweep {
+		return
+	}
+	store.sweep = now
+	for key, bucket := range store.buckets {
+		if now.After(bucket.full) {
+			delete(store.buckets, key)
+		}
+	}
+	for key, sw := range store.windows {
+		if len(sw.times) < 1 || now.Sub(sw.times[len(sw.times)-1]) >= sw.window {
+			delete(store.windows, key)
+		}
+	}
+}
+
+// redisLimitStore 是基于redis的限流store，多实例共享计数
+type redisLimitStore struct {
+	name string
+}
+
+// NewRedisLimitStore 创建使用cache.Cache.Pool[name]的限流store
+func NewRedisLimitStore(name string) LimitStore {
+	return &redisLimitStore{name: name}
+}
+
+// tokenBucketScript 原子地补充并取出一个令牌，返回{是否允许, 等待毫秒}
+const tokenBucketScript = `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local rate = limit / window
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(data[1]) or limit
+local last = tonumber(data[2]) or now
+tokens = math.min(limit, tokens + math.max(0, now - last) * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], window)
+return {allowed, wait}
+`
+
+// slidingWindowScript 原子地清理过期请求并记录本次请求，返回{是否允许, 等待毫秒}
+const slidingWindowScript = `
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return {1, 0}
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return {0, tonumber(oldest[2]) + window - now}
+`
+
+func (store *redisLimitStore) TokenBucket(
+	key string, limit int, window time.Duration) (bool, time.Duration) {
+	return store.eval(tokenBucketScript, key,
+		limit, int64(window/time.Millisecond), nowMillis())
+}
+
+func (store *redisLimitStore) SlidingWindow(
+	key string, limit int, window time.Duration) (bool, time.Duration) {
+	now := nowMillis()
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	member := strconv.FormatInt(now, 10) + "-" + hex.EncodeToString(buf)
+	return store.eval(slidingWindowScript, key,
+		limit, int64(window/time.Millisecond), now, member)
+}
+
+func (store *redisLimitStore) eval(
+	script, key string, args ...interface{}) (bool, time.Duration) {
+	ret, ok := cache.Eval(store.name, script,
+		[]string{"coral:limit:" + key}, args...).([]interface{})
+	if !ok || len(ret) != 2 {
+		return true, 0
+	}
+	allowed, _ := ret[0].(int64)
+	wait, _ := ret[1].(int64)
+	return allowed == 1, time.Duration(wait) * time.Millisecond
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,159 @@
+package coral
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// near 判断d与want的差不超过1%
+func near(d, want time.Duration) bool {
+	diff := d - want
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= want/100
+}
+
+func TestMemoryTokenBucket(t *testing.T) {
+	store := NewMemoryLimitStore().(*memoryLimitStore)
+	for i := 0; i < 3; i++ {
+		if ok, _ := store.TokenBucket("a", 3, time.Minute); !ok {
+			t.Fatalf("request %d limited", i)
+		}
+	}
+	ok, wait := store.TokenBucket("a", 3, time.Minute)
+	if ok || !near(wait, 20*time.Second) {
+		t.Errorf("4th request = %v, wait %v, want limited for 20s", ok, wait)
+	}
+	if ok, _ := store.TokenBucket("b", 3, time.Minute); !ok {
+		t.Errorf("other key limited")
+	}
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration // 距上次请求的时间
+		allowed int
+	}{
+		{"one token", 20 * time.Second, 1},
+		{"half token", 10 * time.Second, 0},
+		{"two tokens", 40 * time.Second, 2},
+		{"capped at limit", time.Hour, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store.buckets["a"] = &tokenBucket{last: time.Now().Add(-test.elapsed)}
+			allowed := 0
+			for i := 0; i < 5; i++ {
+				if ok, _ := store.TokenBucket("a", 3, time.Minute); ok {
+					allowed++
+				}
+			}
+			if allowed != test.allowed {
+				t.Errorf("allowed = %d, want %d", allowed, test.allowed)
+			}
+		})
+	}
+}
+
+func TestMemorySlidingWindow(t *testing.T) {
+	store := NewMemoryLimitStore().(*memoryLimitStore)
+	for i := 0; i < 2; i++ {
+		if ok, _ := store.SlidingWindow("a", 2, time.Minute); !ok {
+			t.Fatalf("request %d limited", i)
+		}
+	}
+	ok, wait := store.SlidingWindow("a", 2, time.Minute)
+	if ok || !near(wait, time.Minute) {
+		t.Errorf("3rd request = %v, wait %v, want limited for 1m", ok, wait)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		times   []time.Time
+		allowed bool
+		wait    time.Duration
+	}{
+		{"oldest expired", []time.Time{now.Add(-time.Minute), now.Add(-time.Second)}, true, 0},
+		{"all in window", []time.Time{now.Add(-40 * time.Second), now.Add(-time.Second)},
+			false, 20 * time.Second},
+		{"empty", nil, true, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store.windows["a"] = &slidingWindow{times: test.times}
+			ok, wait := store.SlidingWindow("a", 2, time.Minute)
+			if ok != test.allowed || (!ok && !near(wait, test.wait)) {
+				t.Errorf("got %v wait %v, want %v wait %v", ok, wait, test.allowed, test.wait)
+			}
+		})
+	}
+}
+
+func TestMemoryLimitClean(t *testing.T) {
+	store := NewMemoryLimitStore().(*memoryLimitStore)
+	now := time.Now()
+	store.sweep = now
+	later := now.Add(memoryLimitSweep)
+	store.buckets["full"] = &tokenBucket{full: later.Add(-time.Second)}
+	store.buckets["refilling"] = &tokenBucket{full: later.Add(time.Minute)}
+	store.windows["expired"] = &slidingWindow{
+		times: []time.Time{later.Add(-2 * time.Minute)}, window: time.Minute}
+	store.windows["active"] = &slidingWindow{
+		times: []time.Time{later.Add(-time.Second)}, window: time.Minute}
+
+	store.clean(later.Add(-time.Second))
+	if len(store.buckets) != 2 || len(store.windows) != 2 {
+		t.Fatalf("cleaned before the sweep interval")
+	}
+	store.clean(later)
+	if _, ok := store.buckets["full"]; ok || len(store.buckets) != 1 {
+		t.Errorf("buckets after clean = %v", store.buckets)
+	}
+	if _, ok := store.windows["expired"]; ok || len(store.windows) != 1 {
+		t.Errorf("windows after clean = %v", store.windows)
+	}
+}
+
+func TestLimiterFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		limiter  func(store LimitStore) *Limiter
+		forms    []url.Values
+		statuses []int
+	}{
+		{"by ip", func(store LimitStore) *Limiter {
+			return NewLimiter(store, SLIDING_WINDOW, 1, time.Minute)
+		}, []url.Values{{}, {}}, []int{STATUS_SUCCESS, STATUS_TOO_MANY_REQUESTS}},
+		{"by param", func(store LimitStore) *Limiter {
+			limiter := NewLimiter(store, TOKEN_BUCKET, 1, time.Minute)
+			limiter.Key = LimitByParam("phone")
+			return limiter
+		}, []url.Values{{"phone": {"1"}}, {"phone": {"2"}}, {"phone": {"1"}}},
+			[]int{STATUS_SUCCESS, STATUS_SUCCESS, STATUS_TOO_MANY_REQUESTS}},
+		{"custom status", func(store LimitStore) *Limiter {
+			limiter := NewLimiter(store, TOKEN_BUCKET, 1, time.Minute)
+			limiter.Status = STATUS_FORBIDDEN
+			return limiter
+		}, []url.Values{{}, {}}, []int{STATUS_SUCCESS, STATUS_FORBIDDEN}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := test.limiter(NewMemoryLimitStore())
+			server := testServer(func(server *Server) {
+				server.NewRouter("/limit", limiter.Filter)
+			})
+			for i, form := range test.forms {
+				resp := serveTest(server, testRequest("POST", "/limit", form))
+				if resp.Status != test.statuses[i] {
+					t.Errorf("request %d status = %d, want %d", i, resp.Status, test.statuses[i])
+				}
+				retry := resp.Header().Get("Retry-After")
+				if limited := test.statuses[i] != STATUS_SUCCESS; limited != (retry == "60") {
+					t.Errorf("request %d Retry-After = %q", i, retry)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package coral
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/coral/config"
+	. "github.com/coral/log"
+)
+
+// authFilters 是已注册的认证filter，key为filter的函数地址
+var (
+	authFilterMux = new(sync.RWMutex)
+	authFilters   = make(map[uintptr]bool)
+)
+
+func init() {
+	RegisterAuthFilter(new(JWTAuth).Filter)
+	RegisterAuthFilter(new(APIKeyAuth).Filter)
+	RegisterAuthFilter(new(SignAuth).Filter)
+}
+
+// RegisterAuthFilter 注册一个设置Principal的认证filter，内置的JWTAuth，APIKeyAuth和SignAuth已经注册
+// 按函数区分，同一类型的Filter方法注册一次即可，如RegisterAuthFilter(new(MyAuth).Filter)
+// 声明了角色或权限的路由中，只有最后一个认证filter及其之前的filter可以在认证之前执行
+func RegisterAuthFilter(filter Filter) {
+	authFilterMux.Lock()
+	defer authFilterMux.Unlock()
+	authFilters[reflect.ValueOf(filter).Pointer()] = true
+}
+
+// isAuthFilter 判断filter是否是注册过的认证filter
+func isAuthFilter(filter Filter) bool {
+	authFilterMux.RLock()
+	defer authFilterMux.RUnlock()
+	return authFilters[reflect.ValueOf(filter).Pointer()]
+}
+
+// lastAuthFilter 返回filterChains中最后一个认证filter的位置，没有时返回-1
+func lastAuthFilter(filterChains []Filter) int {
+	for i := len(filterChains) - 1; i >= 0; i-- {
+		if isAuthFilter(filterChains[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// PermissionResolver 判断调用方是否有指定权限
+type PermissionResolver func(principal *Principal, permission string) bool
+
+// SetPermissionResolver 指定检查Doc.Permissions时使用的权限判断方法
+func (server *Server) SetPermissionResolver(resolver PermissionResolver) {
+	server.permissionResolver = resolver
+}
+
+// RolePermissions 返回按角色授权的PermissionResolver，key为角色，value为该角色的权限
+// 权限为"*"时拥有所有权限
+func RolePermissions(permissions map[string][]string) PermissionResolver {
+	return func(principal *Principal, permission string) bool {
+		for _, role := range principal.Roles {
+			for _, p := range permissions[role] {
+				if p == permission || p == "*" {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// ConfigRolePermissions 从配置中读取角色的权限，配置项名称为角色，权限以逗号分隔
+// conf需要实现All，如IniConfiger
+// [role_permission]
+// ADMIN = *
+// EDITOR = article.read,article.write
+func ConfigRolePermissions(conf config.Configer, group string) PermissionResolver {
+	prefix := strings.ToLower(group) + "."
+	permissions := make(map[string][]string)
+	for name, value := range configAll(conf) {
+		if strings.HasPrefix(name, prefix) {
+			permissions[strings.TrimPrefix(name, prefix)] = splitRoles(value)
+		}
+	}
+	resolver := RolePermissions(permissions)
+	// 配置项名称是小写的
+	return func(principal *Principal, permission string) bool {
+		lower := &Principal{Roles: make([]string, len(principal.Roles))}
+		for i, role := range principal.Roles {
+			lower.Roles[i] = strings.ToLower(role)
+		}
+		return resolver(lower, permission)
+	}
+}
+
+// requiresAuth 判断doc是否声明了角色或权限要求
+func (doc *Doc) requiresAuth() bool {
+	return len(doc.Roles) > 0 || len(doc.Permissions) > 0
+}
+
+// authorize 检查调用方是否满足doc声明的角色和权限
+// Roles满足其中一个即可，Permissions需要全部满足
+func (router *Router) authorize(principal *Principal) bool {
+	doc := router.doc
+	if len(doc.Roles) > 0 {
+		ok := false
+		for _, role := range doc.Roles {
+			if principal.HasRole(role) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			Debug("role required", principal.ID, doc.Roles)
+			return false
+		}
+	}
+	if len(doc.Permissions) < 1 {
+		return true
+	}
+	var resolver PermissionResolver
+	if router.server != nil {
+		resolver = router.server.permissionResolver
+	}
+	if resolver == nil {
+		Error("no permission resolver for", router.path)
+		return false
+	}
+	for _, permission := range doc.Permissions {
+		if !resolver(principal, permission) {
+			Debug("permission required", principal.ID, permission)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package coral
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRBACOrdering(t *testing.T) {
+	var businessRun bool
+	business := func(context *Context) bool {
+		businessRun = true
+		return true
+	}
+	limit := func(context *Context) bool {
+		return true
+	}
+	optional := testJWTAuth()
+	optional.Optional = true
+	resolver := RolePermissions(map[string][]string{"editor": {"article.write"}})
+
+	tests := []struct {
+		name        string
+		roles       []string
+		permissions []string
+		filters     func(jwt *JWTAuth) []Filter
+		token       string
+		status      int
+		businessRun bool
+	}{
+		{"auth filter only", []string{"admin"}, nil,
+			func(jwt *JWTAuth) []Filter { return []Filter{jwt.Filter} },
+			"admin", STATUS_SUCCESS, false},
+		{"auth filter only wrong role", []string{"admin"}, nil,
+			func(jwt *JWTAuth) []Filter { return []Filter{jwt.Filter} },
+			"reader", STATUS_FORBIDDEN, false},
+		{"auth filter only no token", []string{"admin"}, nil,
+			func(jwt *JWTAuth) []Filter { return []Filter{jwt.Filter} },
+			"", STATUS_UNAUTHORIZED, false},
+		{"no filters", []string{"admin"}, nil,
+			func(jwt *JWTAuth) []Filter { return nil },
+			"admin", STATUS_UNAUTHORIZED, false},
+		{"business filter only", []string{"admin"}, nil,
+			func(jwt *JWTAuth) []Filter { return []Filter{business} },
+			"admin", STATUS_UNAUTHORIZED, false},
+		{"filter before auth", []string{"admin"}, nil,
+			func(jwt *JWTAuth) []Filter { return []Filter{limit, jwt.Filter} },
+			"admin", STATUS_SUCCESS, false},
+		{"business after auth", []string{"admin"}, nil,
+			func(jwt *JWTAuth) []Filter { return []Filter{jwt.Filter, business} },
+			"admin", STATUS_SUCCESS, true},
+		{"business after auth wrong role", []string{"admin"}, nil,
+			func(jwt *JWTAuth) []Filter { return []Filter{jwt.Filter, business} },
+			"reader", STATUS_FORBIDDEN, false},
+		{"optional auth without token", []string{"admin"}, nil,
+			func(jwt *JWTAuth) []Filter { return []Filter{optional.Filter, business} },
+			"", STATUS_UNAUTHORIZED, false},
+		{"permission granted", nil, []string{"article.write"},
+			func(jwt *JWTAuth) []Filter { return []Filter{jwt.Filter, business} },
+			"editor", STATUS_SUCCESS, true},
+		{"permission denied", nil, []string{"article.write"},
+			func(jwt *JWTAuth) []Filter { return []Filter{jwt.Filter, business} },
+			"reader", STATUS_FORBIDDEN, false},
+		{"no roles required", nil, nil,
+			func(jwt *JWTAuth) []Filter { return []Filter{business} },
+			"", STATUS_SUCCESS, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			businessRun = false
+			server := testServer(func(server *Server) {
+				server.SetPermissionResolver(resolver)
+				server.NewDocRouter(&Doc{
+					Path:        "/rbac",
+					Roles:       test.roles,
+					Permissions: test.permissions},
+					test.filters(testJWTAuth())...)
+			})
+			req := testRequest("GET", "/rbac", nil)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+testToken(t, test.token))
+			}
+			if status := serveTest(server, req).Status; status != test.status {
+				t.Errorf("status = %d, want %d", status, test.status)
+			}
+			if businessRun != test.businessRun {
+				t.Errorf("business filter run = %v, want %v", businessRun, test.businessRun)
+			}
+		})
+	}
+}
+
+// testAuth 是自定义的认证filter
+type testAuth struct{}
+
+func (auth *testAuth) Filter(context *Context) bool {
+	if strings.HasPrefix(context.GetHeader("Authorization"), "Test ") {
+		context.SetPrincipal(&Principal{ID: "u1", Roles: []string{"admin"}})
+	}
+	return true
+}
+
+func TestRegisterAuthFilter(t *testing.T) {
+	newServer := func() *Server {
+		return testServer(func(server *Server) {
+			server.NewDocRouter(&Doc{Path: "/custom", Roles: []string{"admin"}},
+				new(testAuth).Filter)
+		})
+	}
+	req := testRequest("GET", "/custom", nil)
+	req.Header.Set("Authorization", "Test u1")
+	if status := serveTest(newServer(), req).Status; status != STATUS_UNAUTHORIZED {
+		t.Errorf("unregistered auth filter: status = %d, want %d",
+			status, STATUS_UNAUTHORIZED)
+	}
+	RegisterAuthFilter(new(testAuth).Filter)
+	if status := serveTest(newServer(), req).Status; status != STATUS_SUCCESS {
+		t.Errorf("registered auth filter: status = %d, want %d",
+			status, STATUS_SUCCESS)
+	}
+}
+
+func TestRBACStream(t *testing.T) {
+	var handled bool
+	handler := func(context *Context, stream *Stream) {
+		handled = true
+	}
+	server := testServer(func(server *Server) {
+		server.NewChunkedRouter(&Doc{Path: "/stream", Roles: []string{"admin"}}, handler)
+	})
+	status := serveTest(server, testRequest("GET", "/stream", nil)).Status
+	if status != STATUS_UNAUTHORIZED || handled {
+		t.Errorf("status = %d, handled = %v, want %d and not handled",
+			status, handled, STATUS_UNAUTHORIZED)
+	}
+}
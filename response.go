@@ -0,0 +1,221 @@
+package coral
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "github.com/coral/log"
+)
+
+// responseWriter 包装http.ResponseWriter
+// 记录实际输出的http状态码和字节数，用于访问日志
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.status != 0 {
+		return
+	}
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += int64(n)
+	return n, err
+}
+
+// Flush 将缓冲的数据立即发送给客户端
+func (rw *responseWriter) Flush() {
+	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack 接管底层连接，用于websocket
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	return hijacker.Hijack()
+}
+
+// fileBody 记录需要输出的文件
+type fileBody struct {
+	path       string
+	attachment string // 不为空时作为下载文件名
+}
+
+// Request 返回原始请求
+func (context *Context) Request() *http.Request {
+	return context.req
+}
+
+// GetHeader 返回请求头中key对应的值
+func (context *Context) GetHeader(key string) string {
+	return context.req.Header.Get(key)
+}
+
+// Header 返回响应头，可以直接修改
+func (context *Context) Header() http.Header {
+	return context.w.Header()
+}
+
+// SetHeader 设置响应头
+func (context *Context) SetHeader(key, value string) {
+	context.w.Header().Set(key, value)
+}
+
+// AddHeader 追加响应头
+func (context *Context) AddHeader(key, value string) {
+	context.w.Header().Add(key, value)
+}
+
+// Cookie 返回请求中name对应的cookie值，不存在返回空字符串
+func (context *Context) Cookie(name string) string {
+	cookie, err := context.req.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
+
+// SetCookie 设置响应cookie
+func (context *Context) SetCookie(cookie *http.Cookie) {
+	http.SetCookie(context.w, cookie)
+}
+
+// SetHTTPStatus 指定响应的http状态码
+func (context *Context) SetHTTPStatus(status int) {
+	context.httpStatus = status
+}
+
+// Redirect 重定向到url，status为0时使用302
+func (context *Context) Redirect(url string, status int) {
+	if status == 0 {
+		status = http.StatusFound
+	}
+	context.Raw = true
+	context.redirect = url
+	context.httpStatus = status
+}
+
+// File 直接输出文件内容，支持Range请求
+func (context *Context) File(path string) {
+	context.Raw = true
+	context.file = &fileBody{path: path}
+}
+
+// Attachment 以下载方式输出文件，filename为空时使用文件本身的名字
+func (context *Context) Attachment(path, filename string) {
+	if filename == "" {
+		filename = filepath.Base(path)
+	}
+	context.Raw = true
+	context.file = &fileBody{path: path, attachment: filename}
+}
+
+// writeRaw 输出不做json包装的数据
+func (context *Context) writeRaw() {
+	w := context.w
+	switch {
+	case context.redirect != "":
+		http.Redirect(w, context.req, context.redirect, context.httpStatus)
+		return
+	case context.file != nil:
+		context.writeFile()
+		return
+	}
+	if context.httpStatus != 0 {
+		w.WriteHeader(context.httpStatus)
+	}
+	switch body := context.Data.(type) {
+	case nil:
+	case string:
+		w.Write([]byte(body))
+	case []byte:
+		w.Write(body)
+	case io.Reader:
+		if _, err := io.Copy(w, body); err != nil {
+			Error("raw response write error", context.Path, err.Error())
+		}
+		if closer, ok := body.(io.Closer); ok {
+			closer.Close()
+		}
+	default:
+		w.Write([]byte(fmt.Sprint(body)))
+	}
+}
+
+// writeFile 输出文件，Range和If-Modified-Since由http.ServeContent处理
+func (context *Context) writeFile() {
+	w := context.w
+	file, err := os.Open(context.file.path)
+	if err != nil {
+		Error("open file faild", context.file.path, err.Error())
+		http.NotFound(w, context.req)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		Error("invalid file", context.file.path)
+		http.NotFound(w, context.req)
+		return
+	}
+	name := info.Name()
+	if context.file.attachment != "" {
+		name = context.file.attachment
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(
+			"attachment", map[string]string{"filename": name}))
+	}
+	http.ServeContent(w, context.req, name, info.ModTime(), file)
+}
+
+// genRequestID 返回请求的id
+// 请求头中有合法的X-Request-ID时直接使用，否则生成一个新的id
+func genRequestID(req *http.Request) string {
+	if id := req.Header.Get("X-Request-ID"); id != "" && len(id) <= 128 {
+		valid := true
+		for _, c := range id {
+			if c < 0x21 || c > 0x7e {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return id
+		}
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		Error("generate request id error", err.Error())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// remoteIP 返回请求连接的ip地址
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
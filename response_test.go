@@ -0,0 +1,125 @@
+package coral
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRawResponse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coral")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "data.txt")
+	if err := ioutil.WriteFile(file, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		rangeH string
+		code   int
+		body   string
+		header string // 检查的响应头，格式为key: value
+	}{
+		{"string", func(context *Context) bool {
+			context.Raw = true
+			context.Data = "text"
+			return true
+		}, "", http.StatusOK, "text", ""},
+		{"bytes with status", func(context *Context) bool {
+			context.Raw = true
+			context.Data = []byte("created")
+			context.SetHTTPStatus(http.StatusCreated)
+			return true
+		}, "", http.StatusCreated, "created", ""},
+		{"reader", func(context *Context) bool {
+			context.Raw = true
+			context.Data = strings.NewReader("stream")
+			return true
+		}, "", http.StatusOK, "stream", ""},
+		{"header", func(context *Context) bool {
+			context.Raw = true
+			context.SetHeader("Content-Type", "text/csv")
+			context.Data = "a,b"
+			return true
+		}, "", http.StatusOK, "a,b", "Content-Type: text/csv"},
+		{"redirect", func(context *Context) bool {
+			context.Redirect("/login", 0)
+			return true
+		}, "", http.StatusFound, "<a href=\"/login\">Found</a>.\n\n", "Location: /login"},
+		{"file", func(context *Context) bool {
+			context.File(file)
+			return true
+		}, "", http.StatusOK, "0123456789", ""},
+		{"file range", func(context *Context) bool {
+			context.File(file)
+			return true
+		}, "bytes=2-4", http.StatusPartialContent, "234", "Content-Range: bytes 2-4/10"},
+		{"attachment", func(context *Context) bool {
+			context.Attachment(file, "report.txt")
+			return true
+		}, "", http.StatusOK, "0123456789",
+			"Content-Disposition: attachment; filename=report.txt"},
+		{"missing file", func(context *Context) bool {
+			context.File(filepath.Join(dir, "missing"))
+			return true
+		}, "", http.StatusNotFound, "404 page not found\n", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := testServer(func(server *Server) {
+				server.NewRouter("/raw", test.filter)
+			})
+			req := testRequest("GET", "/raw", nil)
+			if test.rangeH != "" {
+				req.Header.Set("Range", test.rangeH)
+			}
+			resp := serveTest(server, req)
+			if resp.Code != test.code || resp.Body.String() != test.body {
+				t.Errorf("got %d %q, want %d %q",
+					resp.Code, resp.Body.String(), test.code, test.body)
+			}
+			if test.header != "" {
+				kv := strings.SplitN(test.header, ": ", 2)
+				if value := resp.Header().Get(kv[0]); value != kv[1] {
+					t.Errorf("%s = %q, want %q", kv[0], value, kv[1])
+				}
+			}
+		})
+	}
+}
+
+func TestGenRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"valid", "req-123", true},
+		{"empty", "", false},
+		{"space", "req 123", false},
+		{"control", "req\x01", false},
+		{"too long", strings.Repeat("a", 129), false},
+		{"max length", strings.Repeat("a", 128), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := testRequest("GET", "/", nil)
+			req.Header.Set("X-Request-ID", test.header)
+			id := genRequestID(req)
+			if test.keep && id != test.header {
+				t.Errorf("id = %q, want %q", id, test.header)
+			}
+			if !test.keep && (id == test.header || len(id) != 32) {
+				t.Errorf("id = %q, want a generated id", id)
+			}
+		})
+	}
+}
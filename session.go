@@ -0,0 +1,399 @@
+package coral
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coral/cache"
+	"github.com/coral/db"
+	. "github.com/coral/log"
+)
+
+// DefaultSessionCookie 是默认保存session id的cookie名称
+const DefaultSessionCookie = "coral_session"
+
+// DefaultSessionMaxAge 是默认的session空闲过期时间
+const DefaultSessionMaxAge = 30 * time.Minute
+
+// SessionStore 保存session数据，数据以json格式保存
+type SessionStore interface {
+	Load(id string) map[string]interface{} // 不存在或已过期时返回nil
+	Save(id string, values map[string]interface{}, expire time.Duration) bool
+	Touch(id string, expire time.Duration) bool // 延长过期时间
+	Delete(id string)
+}
+
+// Session 是一个请求的session，通过context.Session()取得
+// 数据经过json保存，读取时数字为float64，可以使用Int等方法转换
+type Session struct {
+	id      string
+	values  map[string]interface{}
+	isNew   bool // 新创建的session，还没有保存过
+	changed bool // 数据是否有修改
+	rotate  bool // 是否需要更换session id
+	destroy bool // 是否需要删除session
+
+	manager *SessionManager
+}
+
+// Session 返回当前请求的session，路由没有使用SessionManager.Filter时返回nil
+func (context *Context) Session() *Session {
+	return context.session
+}
+
+// ID 返回session id，新session在请求结束保存时才生成id
+func (session *Session) ID() string {
+	return session.id
+}
+
+// Get 返回key对应的值，不存在返回nil
+func (session *Session) Get(key string) interface{} {
+	return session.values[key]
+}
+
+// Set 设置key对应的值
+func (session *Session) Set(key string, value interface{}) {
+	session.values[key] = value
+	session.changed = true
+}
+
+// Delete 删除key
+func (session *Session) Delete(key string) {
+	if _, ok := session.values[key]; ok {
+		delete(session.values, key)
+		session.changed = true
+	}
+}
+
+// Rotate 在请求结束时更换session id并删除旧的session，数据保留
+// 登录和权限变化时应该调用，防止session固定攻击
+func (session *Session) Rotate() {
+	session.rotate = true
+}
+
+// Destroy 在请求结束时删除session并清除cookie，用于退出登录
+func (session *Session) Destroy() {
+	session.destroy = true
+	session.values = make(map[string]interface{})
+}
+
+// SessionManager 管理session的cookie和保存，通过Filter作为路由的filter使用
+// session在所有filter执行结束后保存
+type SessionManager struct {
+	CookieName string
+	Domain     string
+	Path       string
+	Secure     bool          // 只通过https发送cookie
+	HttpOnly   bool          // 禁止js读取cookie
+	SameSite   http.SameSite // 跨站请求时是否发送cookie
+	MaxAge     time.Duration // 空闲过期时间，每次请求后重新计时
+
+	store SessionStore
+}
+
+// NewSessionManager 创建使用store的session管理
+// 默认cookie为Secure，HttpOnly，SameSite=Lax，空闲30分钟过期
+func NewSessionManager(store SessionStore) *SessionManager {
+	return &SessionManager{
+		CookieName: DefaultSessionCookie,
+		Path:       "/",
+		Secure:     true,
+		HttpOnly:   true,
+		SameSite:   http.SameSiteLaxMode,
+		MaxAge:     DefaultSessionMaxAge,
+		store:      store}
+}
+
+// Filter 根据cookie加载session，不存在或者已过期时创建新的session
+// 新session在有数据时才保存并下发cookie
+func (manager *SessionManager) Filter(context *Context) bool {
+	session := &Session{}
+	if id := context.Cookie(manager.CookieName); id != "" {
+		session.values = manager.store.Load(id)
+		session.id = id
+	}
+	if session.values == nil {
+		// 不使用客户端传来的id，防止session固定攻击
+		session.id = ""
+		session.values = make(map[string]interface{})
+		session.isNew = true
+	}
+	session.manager = manager
+	context.session = session
+	return true
+}
+
+// save 保存session并设置cookie
+func (manager *SessionManager) save(context *Context) {
+	session := context.session
+	if session.destroy {
+		if !session.isNew {
+			manager.store.Delete(session.id)
+			manager.setCookie(context, "", -1)
+		}
+		return
+	}
+	if session.isNew && len(session.values) < 1 {
+		return
+	}
+	if session.rotate && !session.isNew {
+		manager.store.Delete(session.id)
+	}
+	if session.rotate || session.isNew {
+		session.id = randomToken()
+		session.changed = true
+	}
+	var ok bool
+	if session.changed {
+		ok = manager.store.Save(session.id, session.values, manager.MaxAge)
+	} else {
+		ok = manager.store.Touch(session.id, manager.MaxAge)
+	}
+	if !ok {
+		Error("save session faild", session.id)
+		return
+	}
+	manager.setCookie(context, session.id,
+		int(math.Ceil(manager.MaxAge.Seconds())))
+}
+
+func (manager *SessionManager) setCookie(context *Context, id string, maxAge int) {
+	context.SetCookie(&http.Cookie{
+		Name:     manager.CookieName,
+		Value:    id,
+		Domain:   manager.Domain,
+		Path:     manager.Path,
+		MaxAge:   maxAge,
+		Secure:   manager.Secure,
+		HttpOnly: manager.HttpOnly,
+		SameSite: manager.SameSite})
+}
+
+// randomToken 生成256位随机的token，用于session id和csrf token
+func randomToken() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		Error("generate random token error", err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// encodeSession 和 decodeSession 转换session数据和json
+func encodeSession(values map[string]interface{}) (string, bool) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		Error("encode session error", err.Error())
+		return "", false
+	}
+	return string(data), true
+}
+
+func decodeSession(data string) map[string]interface{} {
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &values); err != nil {
+		Error("decode session error", err.Error())
+		return nil
+	}
+	return values
+}
+
+// sessionSweep 是清理过期session的间隔
+const sessionSweep = time.Minute
+
+// memorySessionStore 是单实例使用的内存session store
+type memorySessionStore struct {
+	mux      *sync.Mutex
+	sessions map[string]*memorySession
+	sweep    time.Time // 上次清理过期session的时间
+}
+
+type memorySession struct {
+	data   string
+	expire time.Time
+}
+
+// NewMemorySessionStore 创建内存session store，重启后session丢失，只适用于单实例
+func NewMemorySessionStore() SessionStore {
+	return &memorySessionStore{
+		mux:      new(sync.Mutex),
+		sessions: make(map[string]*memorySession),
+		sweep:    time.Now()}
+}
+
+func (store *memorySessionStore) Load(id string) map[string]interface{} {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	session, ok := store.sessions[id]
+	if !ok || time.Now().After(session.expire) {
+		return nil
+	}
+	return decodeSession(session.data)
+}
+
+func (store *memorySessionStore) Save(
+	id string, values map[string]interface{}, expire time.Duration) bool {
+	data, ok := encodeSession(values)
+	if !ok {
+		return false
+	}
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	now := time.Now()
+	store.clean(now)
+	store.sessions[id] = &memorySession{data: data, expire: now.Add(expire)}
+	return true
+}
+
+func (store *memorySessionStore) Touch(id string, expire time.Duration) bool {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	session, ok := store.sessions[id]
+	if !ok {
+		return false
+	}
+	session.expire = time.Now().Add(expire)
+	return true
+}
+
+func (store *memorySessionStore) Delete(id string) {
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	delete(store.sessions, id)
+}
+
+// clean 定期清理过期的session
+func (store *memorySessionStore) clean(now time.Time) {
+	if now.Sub(store.sweep) < sessionSweep {
+		return
+	}
+	store.sweep = now
+	for id, session := range store.sessions {
+		if now.After(session.expire) {
+			delete(store.sessions, id)
+		}
+	}
+}
+
+// redisSessionStore 是基于redis的session store
+type redisSessionStore struct {
+	name string
+}
+
+// NewRedisSessionStore 创建使用cache.Cache.Pool[name]的session store
+// session保存在coral:session:<id>中，过期由redis处理
+func NewRedisSessionStore(name string) SessionStore {
+	return &redisSessionStore{name: name}
+}
+
+func (store *redisSessionStore) key(id string) string {
+	return "coral:session:" + id
+}
+
+func (store *redisSessionStore) Load(id string) map[string]interface{} {
+	data, ok := cache.Get(store.name, store.key(id)).(string)
+	if !ok {
+		return nil
+	}
+	return decodeSession(data)
+}
+
+func (store *redisSessionStore) Save(
+	id string, values map[string]interface{}, expire time.Duration) bool {
+	data, ok := encodeSession(values)
+	if !ok {
+		return false
+	}
+	return cache.SetEX(store.name, store.key(id), data,
+		int(math.Ceil(expire.Seconds())))
+}
+
+func (store *redisSessionStore) Touch(id string, expire time.Duration) bool {
+	return cache.Expire(store.name, store.key(id), int(math.Ceil(expire.Seconds())))
+}
+
+func (store *redisSessionStore) Delete(id string) {
+	cache.Del(store.name, store.key(id))
+}
+
+// DefaultSessionTable 是db store默认的表名，表结构为
+//
+//	CREATE TABLE session (
+//	  id VARCHAR(64) NOT NULL PRIMARY KEY,
+//	  data TEXT NOT NULL,
+//	  expire_at BIGINT NOT NULL,
+//	  KEY idx_expire_at (expire_at)
+//	)
+const DefaultSessionTable = "session"
+
+// dbSessionStore 是保存在mysql中的session
+type dbSessionStore struct {
+	database string
+	table    string
+	mux      *sync.Mutex
+	sweep    time.Time // 上次删除过期session的时间
+}
+
+// NewDBSessionStore 创建保存在db.DB.Pool[database]中的session store
+// table为空时使用DefaultSessionTable
+func NewDBSessionStore(database, table string) SessionStore {
+	if table == "" {
+		table = DefaultSessionTable
+	}
+	return &dbSessionStore{
+		database: database,
+		table:    table,
+		mux:      new(sync.Mutex),
+		sweep:    time.Now()}
+}
+
+func (store *dbSessionStore) Load(id string) map[string]interface{} {
+	rows := db.Select(store.database,
+		"SELECT data FROM "+store.table+" WHERE id = ? AND expire_at > ?",
+		id, time.Now().Unix())
+	if len(rows) < 1 {
+		return nil
+	}
+	return decodeSession(String(rows[0]["data"]))
+}
+
+func (store *dbSessionStore) Save(
+	id string, values map[string]interface{}, expire time.Duration) bool {
+	data, ok := encodeSession(values)
+	if !ok {
+		return false
+	}
+	store.clean()
+	return db.Update(store.database,
+		"INSERT INTO "+store.table+" (id, data, expire_at) VALUES (?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE data = VALUES(data), expire_at = VALUES(expire_at)",
+		id, data, time.Now().Add(expire).Unix()) >= 0
+}
+
+func (store *dbSessionStore) Touch(id string, expire time.Duration) bool {
+	return db.Update(store.database,
+		"UPDATE "+store.table+" SET expire_at = ? WHERE id = ?",
+		time.Now().Add(expire).Unix(), id) >= 0
+}
+
+func (store *dbSessionStore) Delete(id string) {
+	db.Update(store.database, "DELETE FROM "+store.table+" WHERE id = ?", id)
+}
+
+// clean 定期删除过期的session
+func (store *dbSessionStore) clean() {
+	store.mux.Lock()
+	now := time.Now()
+	if now.Sub(store.sweep) < sessionSweep {
+		store.mux.Unlock()
+		return
+	}
+	store.sweep = now
+	store.mux.Unlock()
+	db.Update(store.database,
+		"DELETE FROM "+store.table+" WHERE expire_at <= ?", now.Unix())
+}
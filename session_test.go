@@ -0,0 +1,156 @@
+package coral
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// sessionServer 创建使用sessions的server，/session根据action操作session并返回name
+func sessionServer(sessions *SessionManager) *Server {
+	return testServer(func(server *Server) {
+		server.NewRouter("/session", sessions.Filter, func(context *Context) bool {
+			session := context.Session()
+			switch context.Params["action"] {
+			case "set":
+				session.Set("name", context.Params["name"])
+			case "rotate":
+				session.Rotate()
+			case "destroy":
+				session.Destroy()
+			}
+			context.Data = session.Get("name")
+			return true
+		})
+	})
+}
+
+// sessionRequest 使用cookie中的session id请求/session?action=action
+func sessionRequest(server *Server, action string, cookie *http.Cookie) *testResponse {
+	req := testRequest("GET", "/session?action="+action+"&name=coral", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	return serveTest(server, req)
+}
+
+func TestSessionNew(t *testing.T) {
+	store := NewMemorySessionStore().(*memorySessionStore)
+	server := sessionServer(NewSessionManager(store))
+
+	tests := []struct {
+		name   string
+		action string
+		cookie *http.Cookie
+	}{
+		{"empty", "", nil},
+		{"unknown id", "", &http.Cookie{Name: DefaultSessionCookie, Value: "unknown"}},
+		{"destroy new", "destroy", nil},
+		{"rotate empty", "rotate", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := sessionRequest(server, test.action, test.cookie)
+			if resp.Status != STATUS_SUCCESS {
+				t.Fatalf("status = %d", resp.Status)
+			}
+			if cookies := resp.Result().Cookies(); len(cookies) > 0 {
+				t.Errorf("empty session set cookies %v", cookies)
+			}
+			if len(store.sessions) > 0 {
+				t.Errorf("empty session saved: %v", store.sessions)
+			}
+		})
+	}
+
+	// 客户端传来的未知id不会被使用
+	resp := sessionRequest(server, "set",
+		&http.Cookie{Name: DefaultSessionCookie, Value: "attacker"})
+	cookie := resp.Cookie(DefaultSessionCookie)
+	if cookie == nil || cookie.Value == "attacker" || len(cookie.Value) != 43 {
+		t.Fatalf("session cookie = %v", cookie)
+	}
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode ||
+		cookie.Path != "/" || cookie.MaxAge != 1800 {
+		t.Errorf("cookie attributes = %+v", cookie)
+	}
+	if _, ok := store.sessions["attacker"]; ok || len(store.sessions) != 1 {
+		t.Errorf("sessions = %v", store.sessions)
+	}
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	store := NewMemorySessionStore().(*memorySessionStore)
+	server := sessionServer(NewSessionManager(store))
+
+	cookie := sessionRequest(server, "set", nil).Cookie(DefaultSessionCookie)
+	if cookie == nil {
+		t.Fatal("no session cookie")
+	}
+	id := cookie.Value
+
+	// 已有session没有修改时只延长过期时间，id不变
+	store.sessions[id].expire = time.Now().Add(time.Second)
+	resp := sessionRequest(server, "", cookie)
+	if resp.Data != "coral" {
+		t.Errorf("data = %v", resp.Data)
+	}
+	if touched := resp.Cookie(DefaultSessionCookie); touched == nil || touched.Value != id {
+		t.Errorf("touched cookie = %v", touched)
+	}
+	if time.Until(store.sessions[id].expire) < time.Minute {
+		t.Errorf("session not touched")
+	}
+
+	// Rotate更换id，删除旧session，数据保留
+	resp = sessionRequest(server, "rotate", cookie)
+	rotated := resp.Cookie(DefaultSessionCookie)
+	if rotated == nil || rotated.Value == id || rotated.Value == "" {
+		t.Fatalf("rotated cookie = %v", rotated)
+	}
+	if _, ok := store.sessions[id]; ok {
+		t.Errorf("old session not deleted")
+	}
+	if values := store.Load(rotated.Value); values["name"] != "coral" {
+		t.Errorf("rotated session = %v", values)
+	}
+	if resp := sessionRequest(server, "", cookie); resp.Data == "coral" ||
+		resp.Cookie(DefaultSessionCookie) != nil {
+		t.Errorf("old id still valid: %v", resp.Data)
+	}
+
+	// Destroy删除session并清除cookie
+	resp = sessionRequest(server, "destroy", rotated)
+	cleared := resp.Cookie(DefaultSessionCookie)
+	if resp.Data == "coral" || cleared == nil || cleared.Value != "" || cleared.MaxAge >= 0 {
+		t.Errorf("destroy data = %v, cookie = %v", resp.Data, cleared)
+	}
+	if len(store.sessions) > 0 {
+		t.Errorf("sessions after destroy = %v", store.sessions)
+	}
+}
+
+func TestMemorySessionStore(t *testing.T) {
+	store := NewMemorySessionStore().(*memorySessionStore)
+	if !store.Save("a", map[string]interface{}{"n": 1}, time.Minute) {
+		t.Fatal("save failed")
+	}
+	if values := store.Load("a"); values["n"] != 1.0 {
+		t.Errorf("load = %v", values)
+	}
+	if store.Touch("missing", time.Minute) {
+		t.Errorf("touched missing session")
+	}
+
+	store.sessions["a"].expire = time.Now().Add(-time.Second)
+	if values := store.Load("a"); values != nil {
+		t.Errorf("expired session loaded: %v", values)
+	}
+
+	// 超过清理间隔后保存时删除过期的session
+	store.sweep = time.Now().Add(-sessionSweep)
+	store.Save("b", map[string]interface{}{}, time.Minute)
+	if _, ok := store.sessions["a"]; ok || len(store.sessions) != 1 {
+		t.Errorf("sessions after clean = %v", store.sessions)
+	}
+}
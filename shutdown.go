@@ -0,0 +1,93 @@
+package coral
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	. "github.com/coral/log"
+)
+
+// DefaultShutdownTimeout 是停止时等待处理中请求结束的默认时间
+const DefaultShutdownTimeout = 30 * time.Second
+
+// SetShutdown 指定优雅停止的参数
+// delay 是就绪检查返回失败后，停止接收新请求前的等待时间，用于负载均衡摘除实例
+// timeout 是等待处理中请求结束的最长时间，为0时使用DefaultShutdownTimeout
+func (server *Server) SetShutdown(delay, timeout time.Duration) {
+	server.shutdownDelay = delay
+	server.shutdownTimeout = timeout
+}
+
+// Shutdown 优雅停止server，Run将在处理中的请求结束后返回
+// websocket连接以1001关闭，并同样等待处理方法返回
+// 收到SIGINT或SIGTERM时会自动调用
+func (server *Server) Shutdown() {
+	if !atomic.CompareAndSwapInt32(&server.shuttingDown, 0, 1) {
+		return
+	}
+	Info("coral shutting down ...")
+	if server.httpServer == nil {
+		return
+	}
+	if server.shutdownDelay > 0 {
+		time.Sleep(server.shutdownDelay)
+	}
+	timeout := server.shutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.httpServer.Shutdown(ctx); err != nil {
+		Error("server shutdown error", err.Error())
+	}
+	server.closeWebSockets(ctx)
+	close(server.stopped)
+}
+
+// closeWebSockets 以1001关闭所有websocket连接，并等待处理方法返回
+// 与http.Server.Shutdown一样轮询，超时后不再等待
+func (server *Server) closeWebSockets(ctx context.Context) {
+	for _, conn := range server.webSockets.list() {
+		conn.closeWith(1001, "server shutting down")
+	}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for server.webSockets.size() > 0 {
+		select {
+		case <-ctx.Done():
+			Error("websocket shutdown timeout", server.webSockets.size())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// serve 启动监听，并在收到停止信号时优雅停止
+func (server *Server) serve() error {
+	server.httpServer = &http.Server{Addr: server.host, Handler: server.mux}
+	server.stopped = make(chan struct{})
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		// 再次收到信号时直接退出
+		signal.Stop(signals)
+		Info("coral received signal", sig.String())
+		server.Shutdown()
+	}()
+
+	err := server.httpServer.ListenAndServe()
+	if err != http.ErrServerClosed {
+		return err
+	}
+	<-server.stopped
+	Info("coral stopped")
+	return nil
+}
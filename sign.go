@@ -0,0 +1,237 @@
+package coral
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coral/cache"
+	"github.com/coral/config"
+	. "github.com/coral/log"
+)
+
+// 签名使用的请求头
+const (
+	SIGN_HEADER_APP_ID    = "X-App-Id"
+	SIGN_HEADER_TIMESTAMP = "X-Timestamp" // unix时间戳，单位秒
+	SIGN_HEADER_NONCE     = "X-Nonce"     // 随机字符串，同一个app在有效期内不能重复
+	SIGN_HEADER_SIGNATURE = "X-Signature" // hex(hmac-sha256(secret, 待签名字符串))
+)
+
+// DefaultSignSkew 是默认允许的客户端时钟误差
+const DefaultSignSkew = 5 * time.Minute
+
+// Canonicalizer 返回请求中参与签名的参数部分
+type Canonicalizer func(req *http.Request) string
+
+// CanonicalRequest 是默认的参数规范化方法
+// query和form参数按名称排序后url编码，json请求体取sha256，两部分用换行连接
+// 上传的文件不参与签名
+func CanonicalRequest(req *http.Request) string {
+	values := req.Form
+	if values == nil {
+		// 客户端签名时解析请求体的副本
+		clone := req.Clone(req.Context())
+		clone.Body = ioutil.NopCloser(bytes.NewReader(readBody(req)))
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+			clone.ParseMultipartForm(DefaultUploadMemory)
+		} else {
+			clone.ParseForm()
+		}
+		values = clone.Form
+	}
+	bodyHash := ""
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/json") {
+		hash := sha256.Sum256(readBody(req))
+		bodyHash = hex.EncodeToString(hash[:])
+	}
+	return values.Encode() + "\n" + bodyHash
+}
+
+// readBody 读取请求体，并重置请求体以便之后再次读取
+func readBody(req *http.Request) []byte {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		Error("read request body error", err.Error())
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body
+}
+
+// stringToSign 返回待签名字符串
+// app id，method，path，timestamp，nonce和规范化的参数用换行连接
+func stringToSign(req *http.Request, appID, timestamp, nonce string,
+	canonicalize Canonicalizer) string {
+	return strings.Join([]string{
+		appID,
+		req.Method,
+		req.URL.EscapedPath(),
+		timestamp,
+		nonce,
+		canonicalize(req)}, "\n")
+}
+
+func hmacSHA256(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignAuth 是签名认证，验证通过后Principal的ID为app id
+type SignAuth struct {
+	Skew         time.Duration // 允许的时钟误差，默认DefaultSignSkew
+	NonceRedis   string        // 记录nonce的cache.Cache.Pool名称，为空时记录在内存中，只适用于单实例
+	Canonicalize Canonicalizer // 参数规范化方法，默认CanonicalRequest
+	Status       int           // 验证失败时返回的status，默认STATUS_INVALID_SIGNATURE
+
+	secret func(appID string) string
+	nonces *nonceCache
+}
+
+// NewSignAuth 创建签名认证，secret根据app id返回密钥，app不存在时返回空字符串
+func NewSignAuth(secret func(appID string) string) *SignAuth {
+	return &SignAuth{
+		Skew:         DefaultSignSkew,
+		Canonicalize: CanonicalRequest,
+		Status:       STATUS_INVALID_SIGNATURE,
+		secret:       secret,
+		nonces:       &nonceCache{mux: new(sync.Mutex), nonces: make(map[string]time.Time)}}
+}
+
+// ConfigSecrets 从配置中读取app的密钥，配置项名称为app id，conf需要实现All，如IniConfiger
+// [sign_secret]
+// PARTNER_A = 9c1f0e7d
+func ConfigSecrets(conf config.Configer, group string) func(string) string {
+	prefix := strings.ToLower(group) + "."
+	secrets := make(map[string]string)
+	for name, secret := range configAll(conf) {
+		if strings.HasPrefix(name, prefix) {
+			secrets[strings.TrimPrefix(name, prefix)] = secret
+		}
+	}
+	return func(appID string) string {
+		return secrets[strings.ToLower(appID)]
+	}
+}
+
+// Filter 验证请求签名，时间戳和nonce
+func (auth *SignAuth) Filter(context *Context) bool {
+	req := context.req
+	appID := req.Header.Get(SIGN_HEADER_APP_ID)
+	timestamp := req.Header.Get(SIGN_HEADER_TIMESTAMP)
+	nonce := req.Header.Get(SIGN_HEADER_NONCE)
+	signature := req.Header.Get(SIGN_HEADER_SIGNATURE)
+	if appID == "" || timestamp == "" || nonce == "" || signature == "" {
+		return auth.fail(context, "signature headers missing")
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return auth.fail(context, "invalid timestamp")
+	}
+	if math.Abs(float64(time.Now().Unix()-ts)) > auth.Skew.Seconds() {
+		return auth.fail(context, "timestamp out of range")
+	}
+	secret := auth.secret(appID)
+	if secret == "" {
+		return auth.fail(context, "unknown app "+appID)
+	}
+	expected := hmacSHA256(secret,
+		stringToSign(req, appID, timestamp, nonce, auth.Canonicalize))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return auth.fail(context, "signature mismatch")
+	}
+	// 签名正确后再记录nonce，避免伪造的请求占用nonce
+	if !auth.useNonce(appID, nonce) {
+		return auth.fail(context, "nonce reused")
+	}
+	context.principal = &Principal{ID: appID, Type: AUTH_SIGNATURE}
+	return true
+}
+
+func (auth *SignAuth) fail(context *Context, reason string) bool {
+	Debug("signature auth faild", reason)
+	context.Status = auth.Status
+	return false
+}
+
+// useNonce 记录nonce，nonce在时钟误差范围内已经用过时返回false
+func (auth *SignAuth) useNonce(appID, nonce string) bool {
+	key := "coral:nonce:" + appID + ":" + nonce
+	expire := 2 * auth.Skew
+	if auth.NonceRedis != "" {
+		return cache.SetNX(auth.NonceRedis, key, 1,
+			int(math.Ceil(expire.Seconds())))
+	}
+	return auth.nonces.add(key, expire)
+}
+
+// nonceCache 是单实例使用的内存nonce记录
+type nonceCache struct {
+	mux    *sync.Mutex
+	nonces map[string]time.Time // nonce -> 过期时间
+	sweep  time.Time
+}
+
+func (nc *nonceCache) add(key string, expire time.Duration) bool {
+	nc.mux.Lock()
+	defer nc.mux.Unlock()
+	now := time.Now()
+	if now.Sub(nc.sweep) > expire {
+		nc.sweep = now
+		for k, t := range nc.nonces {
+			if now.After(t) {
+				delete(nc.nonces, k)
+			}
+		}
+	}
+	if t, ok := nc.nonces[key]; ok && now.Before(t) {
+		return false
+	}
+	nc.nonces[key] = now.Add(expire)
+	return true
+}
+
+// Signer 是调用方使用的签名工具
+type Signer struct {
+	AppID        string
+	Secret       string
+	Canonicalize Canonicalizer // 必须与服务端一致，默认CanonicalRequest
+}
+
+// NewSigner 创建一个签名工具
+func NewSigner(appID, secret string) *Signer {
+	return &Signer{AppID: appID, Secret: secret, Canonicalize: CanonicalRequest}
+}
+
+// Sign 为请求签名，在设置好url，请求体和Content-Type之后调用
+// 如
+// req, _ := http.NewRequest("POST", url, strings.NewReader(form.Encode()))
+// req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+// signer.Sign(req)
+func (signer *Signer) Sign(req *http.Request) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		Error("generate nonce error", err.Error())
+	}
+	nonce := hex.EncodeToString(buf)
+	signature := hmacSHA256(signer.Secret,
+		stringToSign(req, signer.AppID, timestamp, nonce, signer.Canonicalize))
+	req.Header.Set(SIGN_HEADER_APP_ID, signer.AppID)
+	req.Header.Set(SIGN_HEADER_TIMESTAMP, timestamp)
+	req.Header.Set(SIGN_HEADER_NONCE, nonce)
+	req.Header.Set(SIGN_HEADER_SIGNATURE, signature)
+}
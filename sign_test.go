@@ -0,0 +1,180 @@
+package coral
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalRequest(t *testing.T) {
+	newRequest := func(method, target, contentType, body string) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		return req
+	}
+	const form = "application/x-www-form-urlencoded"
+	tests := []struct {
+		name string
+		a, b *http.Request
+		same bool
+	}{
+		{"query order",
+			newRequest("GET", "/s?a=1&b=2", "", ""),
+			newRequest("GET", "/s?b=2&a=1", "", ""), true},
+		{"query value",
+			newRequest("GET", "/s?a=1", "", ""),
+			newRequest("GET", "/s?a=2", "", ""), false},
+		{"form order",
+			newRequest("POST", "/s", form, "a=1&b=2"),
+			newRequest("POST", "/s", form, "b=2&a=1"), true},
+		{"form and query",
+			newRequest("POST", "/s?a=1", form, "b=2"),
+			newRequest("POST", "/s", form, "a=1&b=2"), true},
+		{"json body",
+			newRequest("POST", "/s", "application/json", `{"a":1}`),
+			newRequest("POST", "/s", "application/json", `{"a":2}`), false},
+		{"json whitespace",
+			newRequest("POST", "/s", "application/json", `{"a":1}`),
+			newRequest("POST", "/s", "application/json", `{"a": 1}`), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := CanonicalRequest(test.a), CanonicalRequest(test.b)
+			if (a == b) != test.same {
+				t.Errorf("%q and %q, want same = %v", a, b, test.same)
+			}
+		})
+	}
+
+	// 客户端和服务端解析参数后的结果一致，请求体可以再次读取
+	client := newRequest("POST", "/s?q=1", form, "a=1&b=x+y")
+	clientText := CanonicalRequest(client)
+	body, _ := ioutil.ReadAll(client.Body)
+	if string(body) != "a=1&b=x+y" {
+		t.Errorf("body = %q after canonicalize", body)
+	}
+	server := newRequest("POST", "/s?q=1", form, "a=1&b=x+y")
+	server.ParseForm()
+	if serverText := CanonicalRequest(server); serverText != clientText {
+		t.Errorf("server %q, client %q", serverText, clientText)
+	}
+}
+
+// signRequest 用指定的时间戳和nonce为请求签名
+func signRequest(req *http.Request, appID, secret string, ts time.Time, nonce string) {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	req.Header.Set(SIGN_HEADER_APP_ID, appID)
+	req.Header.Set(SIGN_HEADER_TIMESTAMP, timestamp)
+	req.Header.Set(SIGN_HEADER_NONCE, nonce)
+	req.Header.Set(SIGN_HEADER_SIGNATURE, hmacSHA256(secret,
+		stringToSign(req, appID, timestamp, nonce, CanonicalRequest)))
+}
+
+func TestSignAuth(t *testing.T) {
+	secrets := map[string]string{"app1": "secret1"}
+	auth := NewSignAuth(func(appID string) string {
+		return secrets[appID]
+	})
+	auth.Skew = time.Minute
+	var appID string
+	server := testServer(func(server *Server) {
+		server.NewDocRouter(&Doc{Path: "/sign"}, auth.Filter, func(context *Context) bool {
+			appID = context.Principal().ID
+			return true
+		})
+	})
+	form := url.Values{"a": {"1"}, "b": {"x y"}}
+	newRequest := func() *http.Request {
+		return testRequest("POST", "/sign?q=1", form)
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{"signer", func() *http.Request {
+			req := newRequest()
+			NewSigner("app1", "secret1").Sign(req)
+			return req
+		}, STATUS_SUCCESS},
+		{"within skew", func() *http.Request {
+			req := newRequest()
+			signRequest(req, "app1", "secret1", now.Add(-50*time.Second), "n1")
+			return req
+		}, STATUS_SUCCESS},
+		{"future within skew", func() *http.Request {
+			req := newRequest()
+			signRequest(req, "app1", "secret1", now.Add(50*time.Second), "n2")
+			return req
+		}, STATUS_SUCCESS},
+		{"too old", func() *http.Request {
+			req := newRequest()
+			signRequest(req, "app1", "secret1", now.Add(-2*time.Minute), "n3")
+			return req
+		}, STATUS_INVALID_SIGNATURE},
+		{"too new", func() *http.Request {
+			req := newRequest()
+			signRequest(req, "app1", "secret1", now.Add(2*time.Minute), "n4")
+			return req
+		}, STATUS_INVALID_SIGNATURE},
+		{"nonce replay", func() *http.Request {
+			req := newRequest()
+			signRequest(req, "app1", "secret1", now, "n1")
+			return req
+		}, STATUS_INVALID_SIGNATURE},
+		{"wrong secret", func() *http.Request {
+			req := newRequest()
+			signRequest(req, "app1", "other", now, "n5")
+			return req
+		}, STATUS_INVALID_SIGNATURE},
+		{"unknown app", func() *http.Request {
+			req := newRequest()
+			signRequest(req, "app2", "secret1", now, "n6")
+			return req
+		}, STATUS_INVALID_SIGNATURE},
+		{"tampered body", func() *http.Request {
+			req := newRequest()
+			signRequest(req, "app1", "secret1", now, "n7")
+			req.Body = ioutil.NopCloser(strings.NewReader("a=2&b=x+y"))
+			return req
+		}, STATUS_INVALID_SIGNATURE},
+		{"tampered query", func() *http.Request {
+			req := newRequest()
+			signRequest(req, "app1", "secret1", now, "n8")
+			req.URL.RawQuery = "q=2"
+			return req
+		}, STATUS_INVALID_SIGNATURE},
+		{"missing headers", func() *http.Request {
+			req := newRequest()
+			signRequest(req, "app1", "secret1", now, "n10")
+			req.Header.Del(SIGN_HEADER_NONCE)
+			return req
+		}, STATUS_INVALID_SIGNATURE},
+		{"nonce not used by failed request", func() *http.Request {
+			req := newRequest()
+			signRequest(req, "app1", "secret1", now, "n5")
+			return req
+		}, STATUS_SUCCESS},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			appID = ""
+			status := serveTest(server, test.req()).Status
+			if status != test.status {
+				t.Fatalf("status = %d, want %d", status, test.status)
+			}
+			if status == STATUS_SUCCESS && appID != "app1" {
+				t.Errorf("principal id = %q, want app1", appID)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package coral
+
+import (
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Static 是一个静态文件目录的配置
+// 返回给调用方后仍然可以修改，在处理请求时生效
+type Static struct {
+	Listing bool   // 是否允许列出目录，默认关闭
+	SPA     bool   // 文件不存在时是否返回Index，用于单页应用
+	Index   string // 目录的默认文件，默认index.html
+	MaxAge  int    // Cache-Control的max-age，单位秒，为0时不设置
+
+	prefix string
+	dir    string
+}
+
+// 创建一个静态文件路由，prefix下的请求将映射到dir中的文件
+func (server *Server) Static(prefix, dir string) *Static {
+	if len(prefix) < 1 || prefix[0] != '/' {
+		prefix = "/" + prefix
+	}
+	router, static := newStaticRouter(prefix, dir)
+	server.AddRoute(router)
+	return static
+}
+
+// 添加一个静态文件子路由
+func (router *Router) Static(prefix, dir string) *Static {
+	if len(prefix) < 1 {
+		prefix = "/"
+	}
+	if prefix[0] != '/' && router.path[len(router.path)-1] != '/' {
+		prefix = "/" + prefix
+	}
+	subRouter, static := newStaticRouter(router.path+prefix, dir)
+	router.routers = append(router.routers, subRouter)
+	return static
+}
+
+// newStaticRouter 创建静态文件路由，路由以/结尾以匹配目录下所有请求
+// 静态文件路由不注册doc页面，避免与同名文件冲突
+func newStaticRouter(prefix, dir string) (*Router, *Static) {
+	if prefix[len(prefix)-1] != '/' {
+		prefix = prefix + "/"
+	}
+	static := &Static{
+		Index:  "index.html",
+		prefix: prefix,
+		dir:    dir}
+	router := &Router{}
+	router.path = prefix
+	router.doc = &Doc{
+		Path:        prefix,
+		Description: "static files in " + dir,
+		kind:        "static"}
+	router.handler = router.genStaticHandler(static)
+	return router, static
+}
+
+// genStaticHandler 生成静态文件的处理函数
+func (router *Router) genStaticHandler(
+	static *Static) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		context := router.newContext(w, req)
+		context.Raw = true
+		static.serve(context)
+		router.finish(context)
+	}
+}
+
+func (static *Static) serve(context *Context) {
+	w, req := context.w, context.req
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	upath := path.Clean("/" + strings.TrimPrefix(req.URL.Path, static.prefix))
+	context.Data = "static " + upath
+	for _, part := range strings.Split(upath, "/") {
+		// 不输出隐藏文件
+		if strings.HasPrefix(part, ".") {
+			static.notFound(context)
+			return
+		}
+	}
+
+	name := filepath.Join(static.dir, filepath.FromSlash(upath))
+	info, err := os.Stat(name)
+	if err != nil {
+		static.notFound(context)
+		return
+	}
+	if info.IsDir() {
+		if !strings.HasSuffix(req.URL.Path, "/") {
+			http.Redirect(w, req, path.Base(req.URL.Path)+"/", http.StatusMovedPermanently)
+			return
+		}
+		index := filepath.Join(name, static.Index)
+		if indexInfo, err := os.Stat(index); err == nil && !indexInfo.IsDir() {
+			static.serveFile(context, index, indexInfo)
+			return
+		}
+		if static.Listing {
+			static.list(context, name)
+			return
+		}
+		static.notFound(context)
+		return
+	}
+	static.serveFile(context, name, info)
+}
+
+// notFound 文件不存在时，SPA模式下页面请求返回根目录的Index
+func (static *Static) notFound(context *Context) {
+	req := context.req
+	if static.SPA && path.Ext(req.URL.Path) == "" &&
+		!strings.Contains(req.Header.Get("Accept"), "application/json") {
+		index := filepath.Join(static.dir, static.Index)
+		if info, err := os.Stat(index); err == nil && !info.IsDir() {
+			context.Data = "static spa " + static.Index
+			static.serveFile(context, index, info)
+			return
+		}
+	}
+	http.NotFound(context.w, req)
+}
+
+// serveFile 输出文件，客户端支持gzip且存在name.gz时直接输出压缩文件
+// ETag，Last-Modified和Range由http.ServeContent处理
+func (static *Static) serveFile(context *Context, name string, info os.FileInfo) {
+	w, req := context.w, context.req
+	header := w.Header()
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		header.Set("Content-Type", ctype)
+	}
+	if static.MaxAge > 0 {
+		header.Set("Cache-Control", "public, max-age="+strconv.Itoa(static.MaxAge))
+	}
+	addVary(header, "Accept-Encoding")
+	var file *os.File
+	if acceptEncoding(req, "gzip") {
+		// 压缩文件打开成功后才设置Content-Encoding，失败时输出原文件
+		if gzInfo, err := os.Stat(name + ".gz"); err == nil && !gzInfo.IsDir() {
+			if gzFile, err := os.Open(name + ".gz"); err == nil {
+				header.Set("Content-Encoding", "gzip")
+				file, info = gzFile, gzInfo
+			}
+		}
+	}
+	if file == nil {
+		var err error
+		if file, err = os.Open(name); err != nil {
+			http.NotFound(w, req)
+			return
+		}
+	}
+	defer file.Close()
+	header.Set("ETag", `"`+strconv.FormatInt(info.ModTime().UnixNano(), 36)+
+		"-"+strconv.FormatInt(info.Size(), 36)+`"`)
+	http.ServeContent(w, req, info.Name(), info.ModTime(), file)
+}
+
+// list 输出目录列表
+func (static *Static) list(context *Context, dir string) {
+	file, err := os.Open(dir)
+	if err != nil {
+		http.NotFound(context.w, context.req)
+		return
+	}
+	defer file.Close()
+	infos, err := file.Readdir(-1)
+	if err != nil {
+		http.Error(context.w, "read dir error", http.StatusInternalServerError)
+		return
+	}
+	var names []string
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		if info.IsDir() {
+			name = name + "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := "<!doctype html><pre>\n"
+	for _, name := range names {
+		link := url.URL{Path: name}
+		ret = ret + "<a href='" + link.String() + "'>" + html.EscapeString(name) + "</a>\n"
+	}
+	ret = ret + "</pre>\n"
+	context.w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	context.w.Write([]byte(ret))
+}
+
+// acceptEncoding 判断客户端是否接受指定的压缩方式
+func acceptEncoding(req *http.Request, encoding string) bool {
+	for _, part := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		part = strings.TrimSpace(part)
+		name := part
+		if i := strings.Index(part, ";"); i >= 0 {
+			name = strings.TrimSpace(part[:i])
+			if strings.Replace(part[i+1:], " ", "", -1) == "q=0" {
+				continue
+			}
+		}
+		if strings.EqualFold(name, encoding) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package coral
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// staticDir 创建测试用的静态文件目录
+func staticDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "coral")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"index.html":     "index",
+		"app.js":         "plain js",
+		"app.js.gz":      "gzip js",
+		".secret":        "secret",
+		"sub/page.html":  "page",
+		"empty/.keep":    "",
+		"list/a.txt":     "a",
+		"list/.hidden":   "hidden",
+		"list/child/b.t": "b",
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestStatic(t *testing.T) {
+	dir := staticDir(t)
+	defer os.RemoveAll(dir)
+	server := testServer(func(server *Server) {
+		static := server.Static("/static", dir)
+		static.MaxAge = 60
+		spa := server.Static("/spa", dir)
+		spa.SPA = true
+		listing := server.Static("/listing", dir)
+		listing.Listing = true
+	})
+
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		header   map[string]string
+		code     int
+		body     string
+		encoding string
+	}{
+		{"file", "GET", "/static/app.js", nil, http.StatusOK, "plain js", ""},
+		{"head", "HEAD", "/static/app.js", nil, http.StatusOK, "", ""},
+		{"precompressed", "GET", "/static/app.js",
+			map[string]string{"Accept-Encoding": "br, gzip"},
+			http.StatusOK, "gzip js", "gzip"},
+		{"gzip refused", "GET", "/static/app.js",
+			map[string]string{"Accept-Encoding": "gzip;q=0, deflate"},
+			http.StatusOK, "plain js", ""},
+		{"no precompressed file", "GET", "/static/index.html",
+			map[string]string{"Accept-Encoding": "gzip"},
+			http.StatusOK, "index", ""},
+		{"range", "GET", "/static/app.js",
+			map[string]string{"Range": "bytes=0-4"},
+			http.StatusPartialContent, "plain", ""},
+		{"index", "GET", "/static/", nil, http.StatusOK, "index", ""},
+		{"dir redirect", "GET", "/static/sub", nil, http.StatusMovedPermanently, "", ""},
+		{"dir without index", "GET", "/static/sub/", nil, http.StatusNotFound, "", ""},
+		{"hidden file", "GET", "/static/.secret", nil, http.StatusNotFound, "", ""},
+		{"hidden dir", "GET", "/static/empty/.keep", nil, http.StatusNotFound, "", ""},
+		// mux先清理路径，不会进入静态文件目录之外
+		{"traversal", "GET", "/static/../static_test.go", nil, http.StatusMovedPermanently, "", ""},
+		{"missing", "GET", "/static/missing.js", nil, http.StatusNotFound, "", ""},
+		{"method", "POST", "/static/app.js", nil, http.StatusMethodNotAllowed, "", ""},
+		{"spa page", "GET", "/spa/users/1", nil, http.StatusOK, "index", ""},
+		{"spa asset", "GET", "/spa/missing.js", nil, http.StatusNotFound, "", ""},
+		{"spa json", "GET", "/spa/users/1",
+			map[string]string{"Accept": "application/json"},
+			http.StatusNotFound, "", ""},
+		{"listing", "GET", "/listing/list/", nil, http.StatusOK,
+			"<!doctype html><pre>\n<a href='a.txt'>a.txt</a>\n" +
+				"<a href='child/'>child/</a>\n</pre>\n", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := testRequest(test.method, test.target, nil)
+			for key, value := range test.header {
+				req.Header.Set(key, value)
+			}
+			resp := serveTest(server, req)
+			if resp.Code != test.code {
+				t.Fatalf("code = %d, want %d, body %q", resp.Code, test.code, resp.Body.String())
+			}
+			if test.body != "" && resp.Body.String() != test.body {
+				t.Errorf("body = %q, want %q", resp.Body.String(), test.body)
+			}
+			if encoding := resp.Header().Get("Content-Encoding"); encoding != test.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", encoding, test.encoding)
+			}
+		})
+	}
+}
+
+func TestStaticHeaders(t *testing.T) {
+	dir := staticDir(t)
+	defer os.RemoveAll(dir)
+	server := testServer(func(server *Server) {
+		static := server.Static("/static", dir)
+		static.MaxAge = 60
+	})
+
+	plain := serveTest(server, testRequest("GET", "/static/app.js", nil))
+	etag := plain.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		t.Fatalf("ETag = %q", etag)
+	}
+	if ctype := plain.Header().Get("Content-Type"); !strings.Contains(ctype, "javascript") {
+		t.Errorf("Content-Type = %q", ctype)
+	}
+	if cc := plain.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+	if vary := plain.Header().Get("Vary"); vary != "Accept-Encoding" {
+		t.Errorf("Vary = %q", vary)
+	}
+
+	// 压缩文件和原文件的ETag不同，避免缓存混用
+	req := testRequest("GET", "/static/app.js", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	gzipped := serveTest(server, req)
+	gzEtag := gzipped.Header().Get("ETag")
+	if gzEtag == "" || gzEtag == etag {
+		t.Errorf("gzip ETag = %q, plain ETag = %q", gzEtag, etag)
+	}
+	if ctype := gzipped.Header().Get("Content-Type"); !strings.Contains(ctype, "javascript") {
+		t.Errorf("gzip Content-Type = %q", ctype)
+	}
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		encoding string
+		code     int
+	}{
+		{"if-none-match", "If-None-Match", etag, "", http.StatusNotModified},
+		{"if-none-match gzip", "If-None-Match", gzEtag, "gzip", http.StatusNotModified},
+		{"stale etag", "If-None-Match", `"stale"`, "", http.StatusOK},
+		{"plain etag for gzip", "If-None-Match", etag, "gzip", http.StatusOK},
+		{"if-modified-since", "If-Modified-Since",
+			plain.Header().Get("Last-Modified"), "", http.StatusNotModified},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := testRequest("GET", "/static/app.js", nil)
+			req.Header.Set(test.header, test.value)
+			if test.encoding != "" {
+				req.Header.Set("Accept-Encoding", test.encoding)
+			}
+			if code := serveTest(server, req).Code; code != test.code {
+				t.Errorf("code = %d, want %d", code, test.code)
+			}
+		})
+	}
+}
+
+func TestAcceptEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		accept bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0", false},
+		{"br", false},
+	}
+	for _, test := range tests {
+		req := testRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", test.header)
+		if accept := acceptEncoding(req, "gzip"); accept != test.accept {
+			t.Errorf("acceptEncoding(%q) = %v, want %v", test.header, accept, test.accept)
+		}
+	}
+}
@@ -1,8 +1,315 @@
 package coral
 
+import (
+	"html"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/coral/log"
+)
+
 // 系统保留错误状态码
-const STATUS_SUCCESS = 0        // 成功
-const STATUS_ERROR_UNKNOWN = 1  // 未指定异常
-const STATUS_ERROR_DB = 2       // 数据库异常
-const STATUS_INVALID_PARAM = 3  // 参数校验异常
-const STATUS_INVALID_STATUS = 4 // 输出status超出预期
+const STATUS_SUCCESS = 0           // 成功
+const STATUS_ERROR_UNKNOWN = 1     // 未指定异常
+const STATUS_ERROR_DB = 2          // 数据库异常
+const STATUS_INVALID_PARAM = 3     // 参数校验异常
+const STATUS_INVALID_STATUS = 4    // 输出status超出预期
+const STATUS_TOO_MANY_REQUESTS = 5 // 请求过于频繁
+const STATUS_UNAUTHORIZED = 6      // 认证失败
+const STATUS_INVALID_SIGNATURE = 7 // 签名错误
+const STATUS_FORBIDDEN = 8         // 没有权限
+const STATUS_INVALID_CSRF = 9      // csrf token错误
+
+// DefaultLanguage 请求语言没有对应信息时使用的语言
+var DefaultLanguage = "en"
+
+// statusInfo 记录一个status的名字，对应的http状态码和各语言的信息
+// messages中key为空的是不区分语言的默认信息
+type statusInfo struct {
+	name       string
+	httpStatus int
+	messages   map[string]string
+}
+
+// statusRange 记录一个区间内status的映射
+type statusRange struct {
+	min, max int
+	info     *statusInfo
+}
+
+var (
+	statusMux        = new(sync.RWMutex)
+	statusMap        = make(map[int]*statusInfo)
+	statusRanges     []*statusRange
+	statusDuplicates []string
+)
+
+func init() {
+	RegisterStatus(STATUS_SUCCESS, "STATUS_SUCCESS", nil)
+	RegisterStatus(STATUS_ERROR_UNKNOWN, "STATUS_ERROR_UNKNOWN",
+		map[string]string{"en": "unknown error", "zh": "未知错误"})
+	RegisterStatus(STATUS_ERROR_DB, "STATUS_ERROR_DB",
+		map[string]string{"en": "database error", "zh": "数据库异常"})
+	RegisterStatus(STATUS_INVALID_PARAM, "STATUS_INVALID_PARAM",
+		map[string]string{"en": "invalid param", "zh": "参数错误"})
+	RegisterStatus(STATUS_INVALID_STATUS, "STATUS_INVALID_STATUS",
+		map[string]string{"en": "unexpected status", "zh": "返回状态异常"})
+	RegisterStatus(STATUS_TOO_MANY_REQUESTS, "STATUS_TOO_MANY_REQUESTS",
+		map[string]string{"en": "too many requests", "zh": "请求过于频繁"})
+	RegisterStatus(STATUS_UNAUTHORIZED, "STATUS_UNAUTHORIZED",
+		map[string]string{"en": "unauthorized", "zh": "未认证"})
+	RegisterStatus(STATUS_INVALID_SIGNATURE, "STATUS_INVALID_SIGNATURE",
+		map[string]string{"en": "invalid signature", "zh": "签名错误"})
+	RegisterStatus(STATUS_FORBIDDEN, "STATUS_FORBIDDEN",
+		map[string]string{"en": "forbidden", "zh": "没有权限"})
+	RegisterStatus(STATUS_INVALID_CSRF, "STATUS_INVALID_CSRF",
+		map[string]string{"en": "invalid csrf token", "zh": "csrf token错误"})
+
+	MapStatus(STATUS_SUCCESS, http.StatusOK, "")
+	MapStatus(STATUS_ERROR_UNKNOWN, http.StatusInternalServerError, "")
+	MapStatus(STATUS_ERROR_DB, http.StatusServiceUnavailable, "")
+	MapStatus(STATUS_INVALID_PARAM, http.StatusBadRequest, "")
+	MapStatus(STATUS_INVALID_STATUS, http.StatusInternalServerError, "")
+	MapStatus(STATUS_TOO_MANY_REQUESTS, http.StatusTooManyRequests, "")
+	MapStatus(STATUS_UNAUTHORIZED, http.StatusUnauthorized, "")
+	MapStatus(STATUS_INVALID_SIGNATURE, http.StatusUnauthorized, "")
+	MapStatus(STATUS_FORBIDDEN, http.StatusForbidden, "")
+	MapStatus(STATUS_INVALID_CSRF, http.StatusForbidden, "")
+}
+
+// RegisterStatus 注册一个status，messages的key为语言，如en，zh-CN
+// 未指定errmsg时，系统将根据请求的Accept-Language从messages中选择errmsg
+// 同一个status重复注册，或者同一个name注册给不同的status时，server启动会失败
+func RegisterStatus(status int, name string, messages map[string]string) {
+	statusMux.Lock()
+	defer statusMux.Unlock()
+	info := getStatusInfo(status)
+	if info.name != "" {
+		statusDuplicates = append(statusDuplicates,
+			strconv.Itoa(status)+":"+info.name+","+name)
+	}
+	for code, other := range statusMap {
+		if code != status && other.name == name {
+			statusDuplicates = append(statusDuplicates,
+				name+":"+strconv.Itoa(code)+","+strconv.Itoa(status))
+		}
+	}
+	info.name = name
+	info.addMessages(messages)
+}
+
+// RegisterMessages 为已有的status添加各语言的信息，如为系统status添加日语信息
+// 同一语言的信息会被覆盖，不会作为重复注册
+func RegisterMessages(status int, messages map[string]string) {
+	statusMux.Lock()
+	defer statusMux.Unlock()
+	getStatusInfo(status).addMessages(messages)
+}
+
+// MapStatus 指定status对应的http状态码和默认信息
+// 重复指定会覆盖之前的映射，message为空时不覆盖
+func MapStatus(status, httpStatus int, message string) {
+	statusMux.Lock()
+	defer statusMux.Unlock()
+	info := getStatusInfo(status)
+	info.httpStatus = httpStatus
+	if message != "" {
+		info.messages[""] = message
+	}
+}
+
+// MapStatusRange 指定[min,max]区间内status对应的http状态码和默认信息
+// 用于用户自定义的status区间，如20000-29999
+// 单独指定过http状态码的status优先，区间之间按添加顺序匹配
+func MapStatusRange(min, max, httpStatus int, message string) {
+	statusMux.Lock()
+	defer statusMux.Unlock()
+	info := &statusInfo{
+		httpStatus: httpStatus,
+		messages:   make(map[string]string)}
+	if message != "" {
+		info.messages[""] = message
+	}
+	statusRanges = append(statusRanges, &statusRange{
+		min:  min,
+		max:  max,
+		info: info})
+}
+
+// HTTPStatus 返回status对应的http状态码
+// 未映射的status，成功返回200，其他返回500
+func HTTPStatus(status int) int {
+	statusMux.RLock()
+	defer statusMux.RUnlock()
+	return lookupHTTPStatus(status)
+}
+
+// StatusMessage 返回status在指定语言下的信息
+// lang的格式与Accept-Language请求头一致
+func StatusMessage(status int, lang string) string {
+	statusMux.RLock()
+	defer statusMux.RUnlock()
+	langs := parseAcceptLanguage(lang)
+	if info, ok := statusMap[status]; ok {
+		if message := info.message(langs); message != "" {
+			return message
+		}
+	}
+	for _, r := range statusRanges {
+		if status >= r.min && status <= r.max {
+			if message := r.info.message(langs); message != "" {
+				return message
+			}
+		}
+	}
+	return ""
+}
+
+// checkStatus 检查是否有重复注册的status
+func checkStatus() {
+	statusMux.RLock()
+	defer statusMux.RUnlock()
+	if len(statusDuplicates) > 0 {
+		Fatal("duplicate status registered", statusDuplicates)
+	}
+}
+
+// 获取status的记录，不存在则创建，调用方需要持有写锁
+func getStatusInfo(status int) *statusInfo {
+	info, ok := statusMap[status]
+	if !ok {
+		info = &statusInfo{messages: make(map[string]string)}
+		statusMap[status] = info
+	}
+	return info
+}
+
+// 调用方需要持有读锁
+func lookupHTTPStatus(status int) int {
+	if info, ok := statusMap[status]; ok && info.httpStatus != 0 {
+		return info.httpStatus
+	}
+	for _, r := range statusRanges {
+		if status >= r.min && status <= r.max {
+			return r.info.httpStatus
+		}
+	}
+	if status == STATUS_SUCCESS {
+		return http.StatusOK
+	}
+	return http.StatusInternalServerError
+}
+
+// addMessages 添加各语言的信息，调用方需要持有写锁
+func (info *statusInfo) addMessages(messages map[string]string) {
+	for lang, message := range messages {
+		info.messages[strings.ToLower(lang)] = message
+	}
+}
+
+// message 按语言优先级选择信息
+// 先完全匹配，再匹配主语言，最后使用默认信息和DefaultLanguage
+func (info *statusInfo) message(langs []string) string {
+	for _, lang := range langs {
+		if message, ok := info.messages[lang]; ok {
+			return message
+		}
+		if i := strings.Index(lang, "-"); i > 0 {
+			if message, ok := info.messages[lang[:i]]; ok {
+				return message
+			}
+		}
+	}
+	if message, ok := info.messages[""]; ok {
+		return message
+	}
+	return info.messages[strings.ToLower(DefaultLanguage)]
+}
+
+// parseAcceptLanguage 解析Accept-Language，按q值从高到低返回语言
+func parseAcceptLanguage(header string) []string {
+	type langQ struct {
+		lang string
+		q    float64
+	}
+	var list []langQ
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		q := 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			param := strings.TrimSpace(part[i+1:])
+			part = strings.TrimSpace(part[:i])
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if part == "*" || q <= 0 {
+			continue
+		}
+		list = append(list, langQ{strings.ToLower(part), q})
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].q > list[j].q
+	})
+	langs := make([]string, len(list))
+	for i, l := range list {
+		langs[i] = l.lang
+	}
+	return langs
+}
+
+// genStatusView 生成status列表的doc
+func genStatusView() string {
+	statusMux.RLock()
+	defer statusMux.RUnlock()
+	var codes []int
+	for code := range statusMap {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	ret := "<hr><p>:- status</p><table border='1' cellspacing='0' cellpadding='4'>" +
+		"<tr><th>status</th><th>name</th><th>http</th><th>message</th></tr>"
+	for _, code := range codes {
+		info := statusMap[code]
+		ret = ret + "<tr><td>" + strconv.Itoa(code) + "</td>" +
+			"<td>" + info.name + "</td>" +
+			"<td>" + strconv.Itoa(lookupHTTPStatus(code)) + "</td>" +
+			"<td>" + info.genView() + "</td></tr>"
+	}
+	for _, r := range statusRanges {
+		ret = ret + "<tr><td>" + strconv.Itoa(r.min) + "-" + strconv.Itoa(r.max) +
+			"</td><td></td>" +
+			"<td>" + strconv.Itoa(r.info.httpStatus) + "</td>" +
+			"<td>" + r.info.genView() + "</td></tr>"
+	}
+	return ret + "</table>"
+}
+
+// 生成status各语言信息的doc
+func (info *statusInfo) genView() string {
+	var langs []string
+	for lang := range info.messages {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	ret := ""
+	for _, lang := range langs {
+		if ret != "" {
+			ret = ret + "<br>"
+		}
+		if lang == "" {
+			ret = ret + html.EscapeString(info.messages[lang])
+		} else {
+			ret = ret + lang + ": " + html.EscapeString(info.messages[lang])
+		}
+	}
+	return ret
+}
@@ -0,0 +1,159 @@
+package coral
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHTTPStatus(t *testing.T) {
+	MapStatusRange(9100, 9199, http.StatusNotFound, "")
+	MapStatus(9150, http.StatusConflict, "")
+	tests := []struct {
+		name   string
+		status int
+		code   int
+	}{
+		{"success", STATUS_SUCCESS, http.StatusOK},
+		{"invalid param", STATUS_INVALID_PARAM, http.StatusBadRequest},
+		{"unauthorized", STATUS_UNAUTHORIZED, http.StatusUnauthorized},
+		{"too many requests", STATUS_TOO_MANY_REQUESTS, http.StatusTooManyRequests},
+		{"unmapped", 9001, http.StatusInternalServerError},
+		{"range min", 9100, http.StatusNotFound},
+		{"range max", 9199, http.StatusNotFound},
+		{"range outside", 9200, http.StatusInternalServerError},
+		{"mapped in range", 9150, http.StatusConflict},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if code := HTTPStatus(test.status); code != test.code {
+				t.Errorf("HTTPStatus(%d) = %d, want %d", test.status, code, test.code)
+			}
+		})
+	}
+}
+
+func TestEnableHTTPStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		enable     bool
+		httpStatus int
+		code       int
+	}{
+		{"disabled", false, 0, http.StatusOK},
+		{"mapped", true, 0, http.StatusForbidden},
+		{"explicit", true, http.StatusTeapot, http.StatusTeapot},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := testServer(func(server *Server) {
+				if test.enable {
+					server.EnableHTTPStatus()
+				}
+				server.NewRouter("/status", func(context *Context) bool {
+					context.Status = STATUS_FORBIDDEN
+					if test.httpStatus != 0 {
+						context.SetHTTPStatus(test.httpStatus)
+					}
+					return false
+				})
+			})
+			resp := serveTest(server, testRequest("GET", "/status", nil))
+			if resp.Code != test.code || resp.Status != STATUS_FORBIDDEN {
+				t.Errorf("got %d status %d, want %d status %d",
+					resp.Code, resp.Status, test.code, STATUS_FORBIDDEN)
+			}
+		})
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		langs  []string
+	}{
+		{"", []string{}},
+		{"zh-CN", []string{"zh-cn"}},
+		{"en;q=0.5, zh-CN, fr;q=0.8", []string{"zh-cn", "fr", "en"}},
+		{"da, en-GB;q=0.8, en;q=0.7", []string{"da", "en-gb", "en"}},
+		{"ja;q=0, en", []string{"en"}},
+		{"*, de;q=0.5", []string{"de"}},
+		{"fr;q=bad, en;q=0.9", []string{"fr", "en"}},
+		{" , en ,", []string{"en"}},
+	}
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			langs := parseAcceptLanguage(test.header)
+			if strings.Join(langs, ",") != strings.Join(test.langs, ",") {
+				t.Errorf("parseAcceptLanguage(%q) = %v, want %v",
+					test.header, langs, test.langs)
+			}
+		})
+	}
+}
+
+func TestStatusMessage(t *testing.T) {
+	RegisterStatus(9301, "STATUS_TEST_MESSAGE",
+		map[string]string{"en": "test", "zh": "测试", "pt-BR": "teste"})
+	RegisterMessages(STATUS_FORBIDDEN, map[string]string{"ja": "禁止"})
+	MapStatusRange(9400, 9499, http.StatusBadRequest, "range message")
+	tests := []struct {
+		status int
+		lang   string
+		msg    string
+	}{
+		{9301, "zh-CN,en;q=0.5", "测试"},
+		{9301, "pt-BR", "teste"},
+		{9301, "pt-PT, en;q=0.1", "test"},
+		{9301, "fr", "test"},
+		{9301, "", "test"},
+		{STATUS_FORBIDDEN, "ja", "禁止"},
+		{STATUS_FORBIDDEN, "zh", "没有权限"},
+		{9450, "zh", "range message"},
+		{9001, "en", ""},
+	}
+	for _, test := range tests {
+		if msg := StatusMessage(test.status, test.lang); msg != test.msg {
+			t.Errorf("StatusMessage(%d, %q) = %q, want %q",
+				test.status, test.lang, msg, test.msg)
+		}
+	}
+}
+
+func TestRegisterStatusDuplicate(t *testing.T) {
+	statusMux.Lock()
+	saved := statusDuplicates
+	statusDuplicates = nil
+	statusMux.Unlock()
+	defer func() {
+		statusMux.Lock()
+		statusDuplicates = saved
+		statusMux.Unlock()
+	}()
+
+	RegisterStatus(9501, "STATUS_TEST_A", nil)
+	RegisterMessages(9501, map[string]string{"en": "a"})
+	if len(statusDuplicates) != 0 {
+		t.Fatalf("RegisterMessages reported duplicate: %v", statusDuplicates)
+	}
+	RegisterStatus(9501, "STATUS_TEST_B", nil)
+	RegisterStatus(9502, "STATUS_TEST_B", nil)
+	if len(statusDuplicates) != 2 {
+		t.Errorf("duplicates = %v, want same status and same name", statusDuplicates)
+	}
+}
+
+func TestErrmsgLanguage(t *testing.T) {
+	server := testServer(func(server *Server) {
+		server.NewRouter("/errmsg", func(context *Context) bool {
+			context.Status = STATUS_FORBIDDEN
+			return false
+		})
+	})
+	req := testRequest("GET", "/errmsg", nil)
+	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	resp := serveTest(server, req)
+	if !strings.Contains(resp.Body.String(), "没有权限") {
+		t.Errorf("response = %s, want zh errmsg", resp.Body.String())
+	}
+}
@@ -0,0 +1,287 @@
+package coral
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/coral/log"
+)
+
+// DefaultHeartbeat 是sse连接默认的心跳间隔
+var DefaultHeartbeat = 15 * time.Second
+
+// ErrStreamClosed 在连接已经断开后继续写入时返回
+var ErrStreamClosed = errors.New("stream closed")
+
+// ErrInvalidEvent 在sse事件的id或event包含换行时返回
+var ErrInvalidEvent = errors.New("invalid event id or name")
+
+// sseLineBreak 将\r\n和\r统一为\n，sse中三者都是换行
+var sseLineBreak = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// StreamFilter 是流式接口的处理方法
+// 在参数校验和filterChains全部通过后调用，方法返回时连接结束
+// 参数校验或filter失败时，按普通接口返回json数据
+type StreamFilter func(context *Context, stream *Stream)
+
+// Stream 是流式接口的输出句柄
+// sse接口按text/event-stream格式输出事件，chunked接口直接输出数据块
+type Stream struct {
+	context *Context
+	sse     bool
+	mux     *sync.Mutex
+	done    chan struct{}
+	closed  bool
+	events  int
+}
+
+// 创建一个sse路由
+func (server *Server) NewSSERouter(
+	doc *Doc, stream StreamFilter, filterChains ...Filter) *Router {
+	router := server.NewDocRouter(doc, filterChains...)
+	router.initStream(true, stream, filterChains...)
+	return router
+}
+
+// 创建一个chunked流式输出的路由
+func (server *Server) NewChunkedRouter(
+	doc *Doc, stream StreamFilter, filterChains ...Filter) *Router {
+	router := server.NewDocRouter(doc, filterChains...)
+	router.initStream(false, stream, filterChains...)
+	return router
+}
+
+// 添加一个sse子路由
+func (router *Router) NewSSERouter(
+	doc *Doc, stream StreamFilter, filterChains ...Filter) *Router {
+	subRouter := router.NewDocRouter(doc, filterChains...)
+	subRouter.initStream(true, stream, filterChains...)
+	return subRouter
+}
+
+// 添加一个chunked流式输出的子路由
+func (router *Router) NewChunkedRouter(
+	doc *Doc, stream StreamFilter, filterChains ...Filter) *Router {
+	subRouter := router.NewDocRouter(doc, filterChains...)
+	subRouter.initStream(false, stream, filterChains...)
+	return subRouter
+}
+
+// SetHeartbeat 指定sse路由的心跳间隔，为0时不发送心跳
+func (router *Router) SetHeartbeat(interval time.Duration) *Router {
+	router.heartbeat = interval
+	return router
+}
+
+// initStream 将路由的处理函数替换为流式处理
+func (router *Router) initStream(
+	sse bool, stream StreamFilter, filterChains ...Filter) {
+	if sse {
+		router.doc.kind = "sse"
+		router.heartbeat = DefaultHeartbeat
+	} else {
+		router.doc.kind = "chunked"
+	}
+	router.handler = router.genStreamHandler(sse, stream, filterChains...)
+}
+
+// genStreamHandler 生成流式接口的处理函数
+func (router *Router) genStreamHandler(
+	sse bool,
+	streamFilter StreamFilter,
+	filterChains ...Filter) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		context := router.newContext(w, req)
+		ret, response := router.process(context, filterChains...)
+		if !ret || context.Raw {
+			router.respond(context, ret, response)
+			return
+		}
+
+		if _, ok := w.(http.Flusher); !ok {
+			Error("stream not supported", context.Path)
+			context.Status = STATUS_ERROR_UNKNOWN
+			context.Errmsg = "stream not supported"
+			router.respond(context, false, response)
+			return
+		}
+		stream := &Stream{
+			context: context,
+			sse:     sse,
+			mux:     new(sync.Mutex),
+			done:    make(chan struct{})}
+
+		header := context.w.Header()
+		if sse {
+			header.Set("Content-Type", "text/event-stream")
+			header.Set("Cache-Control", "no-cache")
+			header.Set("Connection", "keep-alive")
+			header.Set("X-Accel-Buffering", "no")
+		} else if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "text/plain; charset=utf-8")
+		}
+		if context.httpStatus != 0 {
+			context.w.WriteHeader(context.httpStatus)
+		} else {
+			context.w.WriteHeader(http.StatusOK)
+		}
+		context.w.Flush()
+
+		go stream.watch(req, router.heartbeat)
+		streamFilter(context, stream)
+		stream.close()
+
+		context.Raw = true
+		context.Data = "stream " + strconv.Itoa(stream.events) + " events"
+		router.finish(context)
+	}
+}
+
+// watch 检测客户端断开，并按间隔发送心跳
+func (stream *Stream) watch(req *http.Request, heartbeat time.Duration) {
+	Bind(stream.context.RequestID)
+	defer Unbind()
+	var tick <-chan time.Time
+	if stream.sse && heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-stream.done:
+			return
+		case <-req.Context().Done():
+			if stream.close() {
+				Debug("stream client disconnected", stream.context.Path)
+			}
+			return
+		case <-tick:
+			stream.Comment("ping")
+		}
+	}
+}
+
+// close 关闭stream，可重复调用，返回本次调用是否执行了关闭
+func (stream *Stream) close() bool {
+	stream.mux.Lock()
+	defer stream.mux.Unlock()
+	if stream.closed {
+		return false
+	}
+	stream.closed = true
+	close(stream.done)
+	return true
+}
+
+// Done 返回一个在连接断开或处理结束时关闭的channel
+func (stream *Stream) Done() <-chan struct{} {
+	return stream.done
+}
+
+// Closed 返回连接是否已经断开
+func (stream *Stream) Closed() bool {
+	stream.mux.Lock()
+	defer stream.mux.Unlock()
+	return stream.closed
+}
+
+// LastEventID 返回客户端重连时携带的Last-Event-ID
+func (stream *Stream) LastEventID() string {
+	id := stream.context.req.Header.Get("Last-Event-ID")
+	if id == "" {
+		// EventSource不能设置请求头时，可以通过参数传递
+		if param, ok := stream.context.Params["lastEventId"]; ok {
+			id = String(param)
+		}
+	}
+	return id
+}
+
+// Send 发送一个sse事件，event为空时为默认的message事件
+// data为string或[]byte时直接输出，其他类型输出json
+func (stream *Stream) Send(event string, data interface{}) error {
+	return stream.SendEvent("", event, data)
+}
+
+// SendEvent 发送一个带id的sse事件，客户端重连时会通过Last-Event-ID带回
+// id和event不能包含换行，否则可以伪造其他字段，返回ErrInvalidEvent
+func (stream *Stream) SendEvent(id, event string, data interface{}) error {
+	if strings.ContainsAny(id, "\r\n\x00") || strings.ContainsAny(event, "\r\n") {
+		Error("invalid stream event", strconv.Quote(id), strconv.Quote(event))
+		return ErrInvalidEvent
+	}
+	var body string
+	switch data := data.(type) {
+	case string:
+		body = data
+	case []byte:
+		body = string(data)
+	default:
+		out, err := json.Marshal(data)
+		if err != nil {
+			Error("stream event marshal error", event, err.Error())
+			return err
+		}
+		body = string(out)
+	}
+	msg := ""
+	if id != "" {
+		msg = msg + "id: " + id + "\n"
+	}
+	if event != "" {
+		msg = msg + "event: " + event + "\n"
+	}
+	for _, line := range strings.Split(sseLineBreak.Replace(body), "\n") {
+		msg = msg + "data: " + line + "\n"
+	}
+	return stream.write([]byte(msg+"\n"), true)
+}
+
+// Retry 通知客户端断开后重连的等待时间
+func (stream *Stream) Retry(retry time.Duration) error {
+	msg := fmt.Sprintf("retry: %d\n\n", retry/time.Millisecond)
+	return stream.write([]byte(msg), false)
+}
+
+// Comment 发送一个sse注释，客户端会忽略，通常用于保持连接
+func (stream *Stream) Comment(text string) error {
+	msg := ""
+	for _, line := range strings.Split(sseLineBreak.Replace(text), "\n") {
+		msg = msg + ": " + line + "\n"
+	}
+	return stream.write([]byte(msg+"\n"), false)
+}
+
+// Write 直接输出一个数据块，用于chunked接口
+func (stream *Stream) Write(data []byte) (int, error) {
+	if err := stream.write(data, true); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (stream *Stream) write(data []byte, event bool) error {
+	stream.mux.Lock()
+	defer stream.mux.Unlock()
+	if stream.closed {
+		return ErrStreamClosed
+	}
+	if _, err := stream.context.w.Write(data); err != nil {
+		Debug("stream write error", stream.context.Path, err.Error())
+		stream.closed = true
+		close(stream.done)
+		return err
+	}
+	stream.context.w.Flush()
+	if event {
+		stream.events++
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package coral
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSSE(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(stream *Stream) error
+		body string
+		err  error
+	}{
+		{"message", func(stream *Stream) error {
+			return stream.Send("", "hello")
+		}, "data: hello\n\n", nil},
+		{"event with id", func(stream *Stream) error {
+			return stream.SendEvent("7", "progress", map[string]int{"done": 7})
+		}, "id: 7\nevent: progress\ndata: {\"done\":7}\n\n", nil},
+		{"multiline data", func(stream *Stream) error {
+			return stream.Send("", "a\nb\r\nc\rd")
+		}, "data: a\ndata: b\ndata: c\ndata: d\n\n", nil},
+		{"injected data field", func(stream *Stream) error {
+			return stream.Send("", "x\rid: 99")
+		}, "data: x\ndata: id: 99\n\n", nil},
+		{"newline in id", func(stream *Stream) error {
+			return stream.SendEvent("1\ndata: forged", "", "x")
+		}, "", ErrInvalidEvent},
+		{"carriage return in event", func(stream *Stream) error {
+			return stream.Send("tick\revent: forged", "x")
+		}, "", ErrInvalidEvent},
+		{"retry", func(stream *Stream) error {
+			return stream.Retry(3 * time.Second)
+		}, "retry: 3000\n\n", nil},
+		{"multiline comment", func(stream *Stream) error {
+			return stream.Comment("a\ndata: b")
+		}, ": a\n: data: b\n\n", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err error
+			server := testServer(func(server *Server) {
+				server.NewSSERouter(&Doc{Path: "/sse"}, func(context *Context, stream *Stream) {
+					err = test.send(stream)
+				})
+			})
+			resp := serveTest(server, testRequest("GET", "/sse", nil))
+			if err != test.err {
+				t.Errorf("err = %v, want %v", err, test.err)
+			}
+			if resp.Body.String() != test.body {
+				t.Errorf("body = %q, want %q", resp.Body.String(), test.body)
+			}
+			if ct := resp.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q", ct)
+			}
+		})
+	}
+}
+
+func TestStreamLastEventID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		target string
+		id     string
+	}{
+		{"header", "5", "/sse", "5"},
+		{"param", "", "/sse?lastEventId=6", "6"},
+		{"header first", "5", "/sse?lastEventId=6", "5"},
+		{"none", "", "/sse", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var id string
+			server := testServer(func(server *Server) {
+				server.NewSSERouter(&Doc{Path: "/sse"}, func(context *Context, stream *Stream) {
+					id = stream.LastEventID()
+				})
+			})
+			req := testRequest("GET", test.target, nil)
+			if test.header != "" {
+				req.Header.Set("Last-Event-ID", test.header)
+			}
+			serveTest(server, req)
+			if id != test.id {
+				t.Errorf("LastEventID = %q, want %q", id, test.id)
+			}
+		})
+	}
+}
+
+func TestChunkedStream(t *testing.T) {
+	server := testServer(func(server *Server) {
+		server.NewChunkedRouter(&Doc{Path: "/chunked"}, func(context *Context, stream *Stream) {
+			stream.Write([]byte("a"))
+			stream.Write([]byte("b"))
+		}, func(context *Context) bool {
+			return context.Params["deny"] == nil
+		})
+	})
+	resp := serveTest(server, testRequest("GET", "/chunked", nil))
+	contentType := resp.Header().Get("Content-Type")
+	if resp.Body.String() != "ab" || contentType != "text/plain; charset=utf-8" {
+		t.Errorf("body = %q, Content-Type = %q", resp.Body.String(), contentType)
+	}
+	// filter失败时按普通接口返回json
+	resp = serveTest(server, testRequest("GET", "/chunked?deny=1", nil))
+	if resp.Status != STATUS_ERROR_UNKNOWN {
+		t.Errorf("denied status = %d, body = %q", resp.Status, resp.Body.String())
+	}
+
+	stream := &Stream{mux: new(sync.Mutex), done: make(chan struct{})}
+	stream.close()
+	if err := stream.Send("", "x"); err != ErrStreamClosed {
+		t.Errorf("send after close = %v, want %v", err, ErrStreamClosed)
+	}
+}
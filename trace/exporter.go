@@ -0,0 +1,50 @@
+package trace
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	. "github.com/coral/log"
+)
+
+// FileExporter 将span按json逐行写入本地文件，不依赖外部服务
+type FileExporter struct {
+	mux  *sync.Mutex
+	file *os.File
+}
+
+// NewFileExporter 创建写入path的exporter，文件不存在时创建
+// 打开文件失败时返回nil的Exporter和错误，不要传给SetExporter
+func NewFileExporter(path string) (Exporter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		Error("open trace file error", path, err.Error())
+		return nil, err
+	}
+	return &FileExporter{mux: new(sync.Mutex), file: file}, nil
+}
+
+// Export 写入一个span
+func (fe *FileExporter) Export(span *Span) {
+	if fe == nil {
+		return
+	}
+	out, err := json.Marshal(span)
+	if err != nil {
+		Error("marshal span error", err.Error())
+		return
+	}
+	fe.mux.Lock()
+	defer fe.mux.Unlock()
+	if _, err := fe.file.Write(append(out, '\n')); err != nil {
+		Error("write trace file error", err.Error())
+	}
+}
+
+// Close 关闭文件
+func (fe *FileExporter) Close() error {
+	fe.mux.Lock()
+	defer fe.mux.Unlock()
+	return fe.file.Close()
+}
@@ -0,0 +1,239 @@
+package trace
+
+// trace模块实现了请求链路追踪
+//
+// span与goroutine绑定，Start创建当前span的子span并成为当前span，End时恢复为父span
+// 当前span与日志标记一样保存在log包的goroutine本地数据中
+// 新启动的goroutine中需要用Attach绑定父span，结束前Detach
+// coral的请求处理，filter，db和cache会自动创建span
+// 通过SetExporter指定exporter后开启追踪，未指定时Start返回nil，所有方法都可以在nil上调用
+//
+// 请求的trace id和父span取自W3C traceparent请求头
+// traceparent的sampled标记为0时，span照常创建和传递，但不会输出到exporter
+// 调用下游服务时用Current().Traceparent()传递，sampled标记保持不变
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/coral/log"
+)
+
+// Span 是一次操作的耗时记录
+type Span struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_id,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	Duration   time.Duration          `json:"duration"` // 纳秒
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+
+	flags  string // traceparent的trace-flags
+	parent *Span
+	owner  int64 // 绑定的goroutine id
+	ended  bool
+}
+
+// Exporter 输出已结束的span
+type Exporter interface {
+	Export(span *Span)
+}
+
+// spanKey 是当前span在goroutine本地数据中的key
+const spanKey = "trace.span"
+
+var (
+	exporter Exporter
+	spanMux  = new(sync.RWMutex)
+)
+
+// SetExporter 指定exporter并开启追踪，为nil时关闭追踪
+func SetExporter(e Exporter) {
+	spanMux.Lock()
+	defer spanMux.Unlock()
+	exporter = e
+}
+
+// Enabled 返回是否开启了追踪
+func Enabled() bool {
+	spanMux.RLock()
+	defer spanMux.RUnlock()
+	return exporter != nil
+}
+
+// Current 返回当前goroutine的span，没有时返回nil
+func Current() *Span {
+	if !Enabled() || !HasLocal() {
+		return nil
+	}
+	return current(GoroutineID())
+}
+
+// current 返回goroutine id对应的当前span
+func current(id int64) *Span {
+	span, _ := GetLocal(id, spanKey).(*Span)
+	return span
+}
+
+// Start 创建当前span的子span，没有当前span时创建新的trace
+func Start(name string) *Span {
+	if !Enabled() {
+		return nil
+	}
+	id := GoroutineID()
+	var parent *Span
+	if HasLocal() {
+		parent = current(id)
+	}
+	span := &Span{
+		SpanID: newID(8),
+		Name:   name,
+		Start:  time.Now(),
+		flags:  "01",
+		parent: parent,
+		owner:  id}
+	if parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		span.flags = parent.flags
+	} else {
+		span.TraceID = newID(16)
+	}
+	SetLocal(id, spanKey, span)
+	return span
+}
+
+// StartRemote 根据traceparent创建span，traceparent无效时创建新的trace
+// 用于处理请求的入口
+func StartRemote(name, traceparent string) *Span {
+	if !Enabled() {
+		return nil
+	}
+	span := Start(name)
+	if traceID, parentID, flags, ok := parseTraceparent(traceparent); ok {
+		span.TraceID = traceID
+		span.ParentID = parentID
+		span.flags = flags
+	}
+	return span
+}
+
+// Attach 在新的goroutine中绑定span，之后创建的span都是它的子span
+// 结束时调用Detach
+func Attach(span *Span) {
+	if span == nil {
+		return
+	}
+	SetLocal(GoroutineID(), spanKey, span)
+}
+
+// Detach 解除当前goroutine绑定的span
+func Detach() {
+	if !HasLocal() {
+		return
+	}
+	SetLocal(GoroutineID(), spanKey, nil)
+}
+
+// Set 添加一个属性
+func (span *Span) Set(key string, value interface{}) {
+	if span == nil {
+		return
+	}
+	if span.Attributes == nil {
+		span.Attributes = make(map[string]interface{})
+	}
+	span.Attributes[key] = value
+}
+
+// SetError 记录错误，err为nil时忽略
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.Error = err.Error()
+}
+
+// End 结束span并输出，当前span恢复为父span
+func (span *Span) End() {
+	if span == nil || span.ended {
+		return
+	}
+	span.ended = true
+	span.Duration = time.Now().Sub(span.Start)
+
+	if current(span.owner) == span {
+		if span.parent != nil && !span.parent.ended {
+			SetLocal(span.owner, spanKey, span.parent)
+		} else {
+			SetLocal(span.owner, spanKey, nil)
+		}
+	}
+	spanMux.RLock()
+	e := exporter
+	spanMux.RUnlock()
+
+	if e != nil && span.Sampled() {
+		e.Export(span)
+	}
+}
+
+// Sampled 返回span是否会输出到exporter，取决于traceparent的sampled标记
+func (span *Span) Sampled() bool {
+	if span == nil {
+		return false
+	}
+	flags, err := strconv.ParseUint(span.flags, 16, 8)
+	return err == nil && flags&0x01 == 1
+}
+
+// Traceparent 返回用于传递给下游服务的traceparent
+func (span *Span) Traceparent() string {
+	if span == nil {
+		return ""
+	}
+	return "00-" + span.TraceID + "-" + span.SpanID + "-" + span.flags
+}
+
+// parseTraceparent 解析traceparent，格式为version-traceid-parentid-flags
+func parseTraceparent(traceparent string) (string, string, string, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		!isHex(parts[1], 32) || !isHex(parts[2], 16) || !isHex(parts[3], 2) {
+		return "", "", "", false
+	}
+	// version 00 必须正好4段
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", "", false
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", "", false
+	}
+	return parts[1], parts[2], parts[3], true
+}
+
+func isHex(str string, length int) bool {
+	if len(str) != length {
+		return false
+	}
+	for _, c := range str {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newID(size int) string {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		Error("generate trace id error", err.Error())
+	}
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,151 @@
+package trace
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/coral/log"
+)
+
+// testExporter 记录输出的span
+type testExporter struct {
+	mux   sync.Mutex
+	spans []*Span
+}
+
+func (te *testExporter) Export(span *Span) {
+	te.mux.Lock()
+	defer te.mux.Unlock()
+	te.spans = append(te.spans, span)
+}
+
+func (te *testExporter) names() []string {
+	te.mux.Lock()
+	defer te.mux.Unlock()
+	var names []string
+	for _, span := range te.spans {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+// withExporter 开启追踪，返回记录span的exporter，结束时调用SetExporter(nil)
+func withExporter() *testExporter {
+	exporter := &testExporter{}
+	SetExporter(exporter)
+	return exporter
+}
+
+func TestSpanNesting(t *testing.T) {
+	exporter := withExporter()
+	defer SetExporter(nil)
+	root := Start("root")
+	child := Start("child")
+	if child.TraceID != root.TraceID || child.ParentID != root.SpanID {
+		t.Errorf("child = %+v, want parent %s", child, root.SpanID)
+	}
+	if Current() != child {
+		t.Errorf("Current() is not the child span")
+	}
+	child.End()
+	child.End() // 重复End不会重复输出
+	if Current() != root {
+		t.Errorf("Current() is not restored to the root span")
+	}
+	root.End()
+	if Current() != nil || HasLocal() {
+		t.Errorf("span still bound after root End")
+	}
+	if names := exporter.names(); len(names) != 2 || names[0] != "child" || names[1] != "root" {
+		t.Errorf("exported = %v", names)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	withExporter()
+	defer SetExporter(nil)
+	root := Start("root")
+	defer root.End()
+	var child *Span
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if Current() != nil {
+			t.Errorf("new goroutine inherits span")
+		}
+		Attach(root)
+		defer Detach()
+		child = Start("async")
+		child.End()
+	}()
+	wg.Wait()
+	if child.ParentID != root.SpanID || Current() != root {
+		t.Errorf("async span parent = %s, want %s", child.ParentID, root.SpanID)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	SetExporter(nil)
+	span := Start("off")
+	if span != nil || Current() != nil {
+		t.Fatalf("span created without exporter")
+	}
+	// nil上可以调用所有方法
+	span.Set("a", 1)
+	span.SetError(nil)
+	span.End()
+	if span.Traceparent() != "" || span.Sampled() {
+		t.Errorf("nil span has traceparent or is sampled")
+	}
+}
+
+func TestStartRemote(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentID = "00f067aa0ba902b7"
+	tests := []struct {
+		name        string
+		traceparent string
+		remote      bool
+		sampled     bool
+	}{
+		{"sampled", "00-" + traceID + "-" + parentID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + parentID + "-00", true, false},
+		{"other flags", "00-" + traceID + "-" + parentID + "-03", true, true},
+		{"future version", "01-" + traceID + "-" + parentID + "-01-extra", true, true},
+		{"empty", "", false, true},
+		{"version ff", "ff-" + traceID + "-" + parentID + "-01", false, true},
+		{"extra field", "00-" + traceID + "-" + parentID + "-01-x", false, true},
+		{"upper case", "00-" + "4BF92F3577B34DA6A3CE929D0E0E4736" + "-" + parentID + "-01", false, true},
+		{"zero trace id", "00-" + "00000000000000000000000000000000" + "-" + parentID + "-01", false, true},
+		{"zero parent id", "00-" + traceID + "-0000000000000000-01", false, true},
+		{"short", "00-" + traceID + "-01", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exporter := withExporter()
+			defer SetExporter(nil)
+			span := StartRemote("request", test.traceparent)
+			child := Start("filter")
+			child.End()
+			span.End()
+			if remote := span.TraceID == traceID && span.ParentID == parentID; remote != test.remote {
+				t.Errorf("span = %s/%s, remote = %v", span.TraceID, span.ParentID, test.remote)
+			}
+			if span.Sampled() != test.sampled || child.Sampled() != test.sampled {
+				t.Errorf("sampled = %v/%v, want %v", span.Sampled(), child.Sampled(), test.sampled)
+			}
+			exported := 0
+			if test.sampled {
+				exported = 2
+			}
+			if names := exporter.names(); len(names) != exported {
+				t.Errorf("exported = %v, want %d spans", names, exported)
+			}
+			// sampled标记原样传递给下游
+			if test.remote && child.Traceparent()[53:] != test.traceparent[53:55] {
+				t.Errorf("traceparent = %s", child.Traceparent())
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package coral
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	. "github.com/coral/log"
+)
+
+// DefaultUploadMemory 是上传文件默认占用内存的上限，超过的部分写入临时文件
+const DefaultUploadMemory int64 = 32 << 20
+
+// Upload 是一个上传的文件
+type Upload struct {
+	Name string `json:"name"` // 客户端提供的文件名
+	Size int64  `json:"size"` // 文件大小，单位byte
+	MIME string `json:"mime"` // 文件类型
+
+	header *multipart.FileHeader
+}
+
+// SetUpload 指定上传文件占用内存的上限和请求体的最大字节数
+// 超过memory的文件会写入临时文件，limit为0时不限制请求大小
+// 请求体超过limit时返回STATUS_INVALID_PARAM和http状态码413
+func (server *Server) SetUpload(memory, limit int64) {
+	server.uploadMemory = memory
+	server.uploadLimit = limit
+}
+
+// Upload 返回name对应的第一个上传文件，不存在返回nil
+func (context *Context) Upload(name string) *Upload {
+	uploads := context.Uploads(name)
+	if len(uploads) < 1 {
+		return nil
+	}
+	return uploads[0]
+}
+
+// Uploads 返回name对应的所有上传文件
+func (context *Context) Uploads(name string) []*Upload {
+	form := context.req.MultipartForm
+	if form == nil {
+		return nil
+	}
+	var uploads []*Upload
+	for _, header := range form.File[name] {
+		uploads = append(uploads, newUpload(header))
+	}
+	return uploads
+}
+
+// Open 打开上传的文件用于流式读取，使用完需要Close
+func (upload *Upload) Open() (multipart.File, error) {
+	return upload.header.Open()
+}
+
+// Save 将上传的文件保存到path
+func (upload *Upload) Save(path string) error {
+	src, err := upload.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func (upload *Upload) String() string {
+	return fmt.Sprintf("upload(%s,%d,%s)", upload.Name, upload.Size, upload.MIME)
+}
+
+// newUpload 根据请求中的文件头创建Upload
+// 客户端没有指定类型时，根据文件内容判断
+func newUpload(header *multipart.FileHeader) *Upload {
+	upload := &Upload{
+		Name:   header.Filename,
+		Size:   header.Size,
+		header: header}
+	mimeType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if err != nil || mimeType == "application/octet-stream" {
+		mimeType = sniffMIME(header)
+	}
+	upload.MIME = mimeType
+	return upload
+}
+
+func sniffMIME(header *multipart.FileHeader) string {
+	file, err := header.Open()
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer file.Close()
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(file, buf)
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	return mimeType
+}
+
+// parseMultipart 解析multipart请求，超过内存上限的文件写入临时文件
+func (router *Router) parseMultipart(req *http.Request) error {
+	memory := DefaultUploadMemory
+	if router.server != nil && router.server.uploadMemory > 0 {
+		memory = router.server.uploadMemory
+	}
+	return req.ParseMultipartForm(memory)
+}
+
+// processUploads 将上传文件加入params
+// 同名多个文件时params中只保留第一个，全部文件通过Context.Uploads获取
+func processUploads(form *multipart.Form, params map[string]interface{}) {
+	for k, headers := range form.File {
+		if len(headers) > 0 {
+			params[k] = newUpload(headers[0])
+		}
+	}
+}
+
+// FileRule 生成一个file规则，maxSize为0时不限制大小
+// 如 FileRule(1<<20, "image/png", "image/*")
+func FileRule(maxSize int64, mimes ...string) string {
+	rule := "file"
+	if maxSize > 0 {
+		rule = rule + "[0," + strconv.FormatInt(maxSize, 10) + "]"
+	}
+	if len(mimes) > 0 {
+		rule = rule + "|file{" + strings.Join(mimes, ",") + "}"
+	}
+	return rule
+}
+
+// hasFile 判断规则中是否有上传文件
+func (field Checker) hasFile() bool {
+	for _, value := range field {
+		switch value := value.(type) {
+		case Checker:
+			if value.hasFile() {
+				return true
+			}
+		case string:
+			for _, rule := range strings.Split(value, "|") {
+				if strings.HasPrefix(rule, "file") {
+					return true
+				}
+			}
+		case []string:
+			for _, rule := range value {
+				if strings.Contains(rule, "file") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// checkFile 检查上传文件的大小和类型
+// file[m,n] 文件大小不小于m不大于n
+// file{a,b} 文件类型是a,b其中一个，支持image/*的形式
+func checkFile(rule string, upload *Upload) bool {
+	rule = rule[4:]
+	if len(rule) > 0 {
+		switch rule[0] {
+		case '[':
+			return checkRange(rule, int(upload.Size))
+		case '{':
+			return checkMIME(rule, upload.MIME)
+		}
+	}
+	return true
+}
+
+func checkMIME(rule, mimeType string) bool {
+	if len(rule) > 2 && rule[0] == '{' && rule[len(rule)-1] == '}' {
+		for _, ex := range strings.Split(rule[1:len(rule)-1], ",") {
+			ex = strings.TrimSpace(ex)
+			if ex == mimeType ||
+				(strings.HasSuffix(ex, "/*") &&
+					strings.HasPrefix(mimeType, ex[:len(ex)-1])) {
+				return true
+			}
+		}
+		Debug("check mime faild", rule, mimeType)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,502 @@
+package coral
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/coral/log"
+)
+
+// websocket帧类型
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocketMaxMessage 是websocket单个消息的最大字节数
+var WebSocketMaxMessage int64 = 1 << 20
+
+// WebSocketWriteTimeout 是websocket写入一个消息的超时时间
+var WebSocketWriteTimeout = 10 * time.Second
+
+// WebSocketFilter 是websocket消息的处理方法
+// message是客户端发送的json对象，已经通过Doc.Message的校验
+// 返回false时服务端关闭连接
+type WebSocketFilter func(conn *WebSocketConn, message map[string]interface{}) bool
+
+// WebSocketConn 是一个websocket连接
+type WebSocketConn struct {
+	id      int64
+	context *Context
+	router  *Router
+	conn    net.Conn
+	reader  *bufio.Reader
+
+	writeMux *sync.Mutex
+	done     chan struct{}
+	once     *sync.Once
+	messages int
+}
+
+// webSocketHub 记录一个路由上所有的websocket连接，用于广播
+type webSocketHub struct {
+	mux   *sync.RWMutex
+	conns map[int64]*WebSocketConn
+}
+
+var webSocketID int64
+
+var (
+	errWebSocketProtocol = errors.New("websocket protocol error")
+	errWebSocketTooBig   = errors.New("websocket message too big")
+)
+
+// 添加一个websocket子路由
+// filterChains在握手时执行，可以用于鉴权，握手参数通过Doc.Input校验
+// 连接建立后每个消息通过Doc.Message校验后交给handler处理
+func (router *Router) NewWebSocketRouter(
+	doc *Doc, handler WebSocketFilter, filterChains ...Filter) *Router {
+	subRouter := router.NewDocRouter(doc, filterChains...)
+	subRouter.initWebSocket(handler, filterChains...)
+	return subRouter
+}
+
+// 创建一个websocket路由
+func (server *Server) NewWebSocketRouter(
+	doc *Doc, handler WebSocketFilter, filterChains ...Filter) *Router {
+	router := server.NewDocRouter(doc, filterChains...)
+	router.initWebSocket(handler, filterChains...)
+	return router
+}
+
+// Broadcast 向该路由上所有的websocket连接发送数据
+func (router *Router) Broadcast(data interface{}) {
+	if router.hub == nil {
+		return
+	}
+	out, err := json.Marshal(data)
+	if err != nil {
+		Error("websocket broadcast marshal error", router.path, err.Error())
+		return
+	}
+	for _, conn := range router.Connections() {
+		conn.writeFrame(wsText, out)
+	}
+}
+
+// Connections 返回该路由上当前所有的websocket连接
+func (router *Router) Connections() []*WebSocketConn {
+	if router.hub == nil {
+		return nil
+	}
+	return router.hub.list()
+}
+
+// initWebSocket 将路由的处理函数替换为websocket处理
+func (router *Router) initWebSocket(
+	handler WebSocketFilter, filterChains ...Filter) {
+	router.doc.kind = "websocket"
+	router.heartbeat = DefaultHeartbeat
+	router.hub = newWebSocketHub()
+	router.handler = router.genWebSocketHandler(handler, filterChains...)
+}
+
+// genWebSocketHandler 生成websocket的处理函数
+func (router *Router) genWebSocketHandler(
+	handler WebSocketFilter,
+	filterChains ...Filter) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		context := router.newContext(w, req)
+		if !isWebSocketUpgrade(req) {
+			context.Status = STATUS_INVALID_PARAM
+			context.Errmsg = "websocket upgrade required"
+			context.httpStatus = http.StatusBadRequest
+			router.respond(context, false, &Response{})
+			return
+		}
+		if !router.checkOrigin(req) {
+			Debug("websocket origin denied", context.Path, req.Header.Get("Origin"))
+			context.Status = STATUS_FORBIDDEN
+			context.httpStatus = http.StatusForbidden
+			router.respond(context, false, &Response{})
+			return
+		}
+		ret, response := router.process(context, filterChains...)
+		if !ret || context.Raw {
+			router.respond(context, ret, response)
+			return
+		}
+
+		server := router.server
+		if atomic.LoadInt32(&server.shuttingDown) == 1 {
+			context.Status = STATUS_ERROR_UNKNOWN
+			context.httpStatus = http.StatusServiceUnavailable
+			router.respond(context, false, &Response{})
+			return
+		}
+		conn, err := router.upgrade(context)
+		if err != nil {
+			Error("websocket upgrade faild", context.Path, err.Error())
+			return
+		}
+		// 接管后的连接不在http.Server.Shutdown的等待范围内，由server记录
+		router.hub.add(conn)
+		server.webSockets.add(conn)
+		if atomic.LoadInt32(&server.shuttingDown) == 1 {
+			// 记录之前server开始停止，可能已经错过了关闭
+			conn.closeWith(1001, "server shutting down")
+		}
+		conn.serve(handler)
+		router.hub.remove(conn)
+		server.webSockets.remove(conn)
+
+		context.Raw = true
+		context.Data = "websocket " + strconv.Itoa(conn.messages) + " messages"
+		router.finish(context)
+	}
+}
+
+func isWebSocketUpgrade(req *http.Request) bool {
+	return req.Method == "GET" &&
+		headerContains(req.Header, "Connection", "upgrade") &&
+		headerContains(req.Header, "Upgrade", "websocket") &&
+		req.Header.Get("Sec-WebSocket-Version") == "13" &&
+		req.Header.Get("Sec-WebSocket-Key") != ""
+}
+
+// checkOrigin 检查浏览器发起握手的Origin，防止其他站点带着cookie建立连接
+// 没有Origin的非浏览器客户端和同源请求允许连接
+// 跨域请求需要匹配路由跨域策略中明确指定的Origin，不接受*
+func (router *Router) checkOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, req.Host) {
+		return true
+	}
+	cors := router.corsPolicy()
+	return cors != nil && cors.allowOrigin(origin, false)
+}
+
+// headerContains 判断以逗号分隔的请求头中是否包含token，不区分大小写
+func headerContains(header http.Header, key, token string) bool {
+	for _, value := range header[http.CanonicalHeaderKey(key)] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgrade 完成websocket握手，filter中设置的响应头会一并返回
+func (router *Router) upgrade(context *Context) (*WebSocketConn, error) {
+	netConn, buf, err := context.w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum([]byte(context.req.Header.Get("Sec-WebSocket-Key") + wsGUID))
+	header := context.w.Header()
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(hash[:]))
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(buf)
+	buf.WriteString("\r\n")
+	if err := buf.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	context.w.status = http.StatusSwitchingProtocols
+	return &WebSocketConn{
+		id:       atomic.AddInt64(&webSocketID, 1),
+		context:  context,
+		router:   router,
+		conn:     netConn,
+		reader:   buf.Reader,
+		writeMux: new(sync.Mutex),
+		done:     make(chan struct{}),
+		once:     new(sync.Once)}, nil
+}
+
+// ID 返回连接的唯一id
+func (conn *WebSocketConn) ID() int64 {
+	return conn.id
+}
+
+// Context 返回握手请求的上下文，filter中设置的数据都可以从中获取
+func (conn *WebSocketConn) Context() *Context {
+	return conn.context
+}
+
+// Done 返回一个在连接关闭时关闭的channel
+func (conn *WebSocketConn) Done() <-chan struct{} {
+	return conn.done
+}
+
+// Send 向客户端发送json数据
+func (conn *WebSocketConn) Send(data interface{}) error {
+	out, err := json.Marshal(data)
+	if err != nil {
+		Error("websocket message marshal error", conn.context.Path, err.Error())
+		return err
+	}
+	return conn.writeFrame(wsText, out)
+}
+
+// Reply 按Response的格式向客户端发送数据
+// errmsg为空时根据status从注册的信息中选择
+func (conn *WebSocketConn) Reply(status int, data interface{}, errmsg string) error {
+	if errmsg == "" {
+		errmsg = StatusMessage(
+			status, conn.context.req.Header.Get("Accept-Language"))
+	}
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	return conn.Send(&Response{Status: status, Data: data, Errmsg: errmsg})
+}
+
+// Close 关闭连接
+func (conn *WebSocketConn) Close() {
+	conn.closeWith(1000, "")
+}
+
+func (conn *WebSocketConn) closeWith(code int, reason string) {
+	conn.once.Do(func() {
+		payload := make([]byte, 2, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		payload = append(payload, reason...)
+		conn.writeFrame(wsClose, payload)
+		close(conn.done)
+		conn.conn.Close()
+	})
+}
+
+// serve 读取并处理客户端消息，直到连接关闭
+func (conn *WebSocketConn) serve(handler WebSocketFilter) {
+	defer conn.Close()
+	go conn.ping(conn.router.heartbeat)
+
+	var message []byte
+	var messageType byte
+	for {
+		if conn.router.heartbeat > 0 {
+			conn.conn.SetReadDeadline(time.Now().Add(2 * conn.router.heartbeat))
+		}
+		fin, opcode, payload, err := conn.readFrame()
+		if err != nil {
+			if err == errWebSocketProtocol {
+				conn.closeWith(1002, "protocol error")
+			} else if err == errWebSocketTooBig {
+				conn.closeWith(1009, "message too big")
+			} else if err != io.EOF {
+				Debug("websocket read error", conn.context.Path, err.Error())
+			}
+			return
+		}
+		switch opcode {
+		case wsPing:
+			conn.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			return
+		case wsText, wsBinary:
+			messageType = opcode
+			message = payload
+		case wsContinuation:
+			message = append(message, payload...)
+		default:
+			conn.closeWith(1002, "unknown opcode")
+			return
+		}
+		if int64(len(message)) > WebSocketMaxMessage {
+			conn.closeWith(1009, "message too big")
+			return
+		}
+		if !fin {
+			continue
+		}
+		if messageType != wsText {
+			conn.closeWith(1003, "text message only")
+			return
+		}
+		conn.messages++
+		if !conn.handle(handler, message) {
+			return
+		}
+		message = nil
+	}
+}
+
+// handle 校验并处理一个完整的消息，返回false时关闭连接
+func (conn *WebSocketConn) handle(handler WebSocketFilter, data []byte) bool {
+	var message map[string]interface{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		Debug("websocket message is not json object", conn.context.Path, string(data))
+		conn.Reply(STATUS_INVALID_PARAM, nil, "")
+		return true
+	}
+	if checker := conn.router.doc.Message; checker != nil {
+		if ret, status := checker.check(message); !ret {
+			Debug("websocket message check faild", conn.context.Path, message)
+			conn.Reply(status, nil, "")
+			return true
+		}
+	}
+	return handler(conn, message)
+}
+
+// ping 按间隔发送ping，客户端没有响应时读超时会断开连接
+func (conn *WebSocketConn) ping(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	Bind(conn.context.RequestID)
+	defer Unbind()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conn.done:
+			return
+		case <-ticker.C:
+			if err := conn.writeFrame(wsPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readFrame 读取一个客户端帧，客户端的帧必须有掩码
+func (conn *WebSocketConn) readFrame() (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn.reader, header); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, errWebSocketProtocol
+	}
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(conn.reader, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(conn.reader, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext))
+	}
+	if length < 0 {
+		return false, 0, nil, errWebSocketProtocol
+	}
+	if length > WebSocketMaxMessage {
+		return false, 0, nil, errWebSocketTooBig
+	}
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(conn.reader, mask); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame 写入一个完整的服务端帧，服务端的帧不加掩码
+func (conn *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	conn.writeMux.Lock()
+	defer conn.writeMux.Unlock()
+	select {
+	case <-conn.done:
+		return ErrStreamClosed
+	default:
+	}
+	frame := []byte{0x80 | opcode}
+	length := len(payload)
+	switch {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	frame = append(frame, payload...)
+	conn.conn.SetWriteDeadline(time.Now().Add(WebSocketWriteTimeout))
+	_, err := conn.conn.Write(frame)
+	if err != nil {
+		Debug("websocket write error", conn.context.Path, err.Error())
+	}
+	return err
+}
+
+func newWebSocketHub() *webSocketHub {
+	return &webSocketHub{
+		mux:   new(sync.RWMutex),
+		conns: make(map[int64]*WebSocketConn)}
+}
+
+func (hub *webSocketHub) add(conn *WebSocketConn) {
+	hub.mux.Lock()
+	defer hub.mux.Unlock()
+	hub.conns[conn.id] = conn
+}
+
+func (hub *webSocketHub) remove(conn *WebSocketConn) {
+	hub.mux.Lock()
+	defer hub.mux.Unlock()
+	delete(hub.conns, conn.id)
+}
+
+func (hub *webSocketHub) list() []*WebSocketConn {
+	hub.mux.RLock()
+	defer hub.mux.RUnlock()
+	var conns []*WebSocketConn
+	for _, conn := range hub.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+func (hub *webSocketHub) size() int {
+	hub.mux.RLock()
+	defer hub.mux.RUnlock()
+	return len(hub.conns)
+}
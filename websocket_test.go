@@ -0,0 +1,290 @@
+package coral
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testWebSocket 是测试用的websocket客户端
+type testWebSocket struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dialWebSocket 连接ts上的websocket路由，返回握手的响应
+func dialWebSocket(t *testing.T, ts *httptest.Server, path string,
+	header http.Header) (*testWebSocket, *http.Response) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req, _ := http.NewRequest("GET", ts.URL+path, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testWebSocket{t: t, conn: conn, reader: reader}, resp
+}
+
+// send 发送一个客户端帧，masked为false时不加掩码
+func (ws *testWebSocket) send(opcode byte, fin, masked bool, payload []byte) {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	second := byte(0)
+	if masked {
+		second = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, second|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, second|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	default:
+		frame = append(frame, second|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(len(payload)))
+	}
+	data := append([]byte(nil), payload...)
+	if masked {
+		mask := []byte{1, 2, 3, 4}
+		frame = append(frame, mask...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	if _, err := ws.conn.Write(append(frame, data...)); err != nil {
+		ws.t.Fatal(err)
+	}
+}
+
+// read 读取一个服务端帧
+func (ws *testWebSocket) read() (byte, []byte) {
+	header := make([]byte, 2)
+	if _, err := ws.reader.Read(header[:1]); err != nil {
+		ws.t.Fatal(err)
+	}
+	if _, err := ws.reader.Read(header[1:]); err != nil {
+		ws.t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		readFull(ws.t, ws.reader, ext)
+		length = int(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		readFull(ws.t, ws.reader, ext)
+		length = int(binary.BigEndian.Uint64(ext))
+	}
+	payload := make([]byte, length)
+	readFull(ws.t, ws.reader, payload)
+	return header[0] & 0x0f, payload
+}
+
+func readFull(t *testing.T, reader *bufio.Reader, buf []byte) {
+	for n := 0; n < len(buf); {
+		m, err := reader.Read(buf[n:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += m
+	}
+}
+
+// closeCode 返回close帧中的状态码
+func closeCode(payload []byte) int {
+	if len(payload) < 2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(payload))
+}
+
+// echoServer 返回回显消息的websocket server，收到quit时关闭连接
+func echoServer() (*Server, *httptest.Server) {
+	server := testServer(func(server *Server) {
+		server.NewWebSocketRouter(&Doc{Path: "/ws"},
+			func(conn *WebSocketConn, message map[string]interface{}) bool {
+				if message["quit"] != nil {
+					return false
+				}
+				conn.Send(message)
+				return true
+			})
+	})
+	return server, httptest.NewServer(server.mux)
+}
+
+func TestWebSocketFrames(t *testing.T) {
+	_, ts := echoServer()
+	defer ts.Close()
+	saved := WebSocketMaxMessage
+	WebSocketMaxMessage = 64
+	defer func() { WebSocketMaxMessage = saved }()
+
+	type frame struct {
+		opcode  byte
+		fin     bool
+		masked  bool
+		payload string
+	}
+	tests := []struct {
+		name    string
+		frames  []frame
+		opcode  byte
+		payload string // close帧时为空，检查code
+		code    int
+	}{
+		{"text", []frame{{wsText, true, true, `{"a":1}`}},
+			wsText, `{"a":1}`, 0},
+		{"fragmented", []frame{
+			{wsText, false, true, `{"a":`},
+			{wsPing, true, true, "p"},
+			{wsContinuation, true, true, `2}`}},
+			wsPong, "p", 0},
+		{"ping", []frame{{wsPing, true, true, "hi"}},
+			wsPong, "hi", 0},
+		{"not json", []frame{{wsText, true, true, "hello"}},
+			wsText, `{"status":3,"data":{},"errmsg":"invalid param"}`, 0},
+		{"unmasked", []frame{{wsText, true, false, `{"a":1}`}},
+			wsClose, "", 1002},
+		{"binary", []frame{{wsBinary, true, true, `{"a":1}`}},
+			wsClose, "", 1003},
+		{"unknown opcode", []frame{{0x3, true, true, ""}},
+			wsClose, "", 1002},
+		{"frame too big", []frame{{wsText, true, true, strings.Repeat("a", 65)}},
+			wsClose, "", 1009},
+		{"message too big", []frame{
+			{wsText, false, true, strings.Repeat("a", 40)},
+			{wsContinuation, true, true, strings.Repeat("a", 40)}},
+			wsClose, "", 1009},
+		{"handler closes", []frame{{wsText, true, true, `{"quit":1}`}},
+			wsClose, "", 1000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ws, resp := dialWebSocket(t, ts, "/ws", nil)
+			defer ws.conn.Close()
+			if resp.StatusCode != http.StatusSwitchingProtocols ||
+				resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+				t.Fatalf("handshake %d %v", resp.StatusCode, resp.Header)
+			}
+			for _, f := range test.frames {
+				ws.send(f.opcode, f.fin, f.masked, []byte(f.payload))
+			}
+			opcode, payload := ws.read()
+			if opcode != test.opcode {
+				t.Fatalf("opcode = %x, want %x, payload %q", opcode, test.opcode, payload)
+			}
+			if opcode == wsClose {
+				if code := closeCode(payload); code != test.code {
+					t.Errorf("close code = %d, want %d", code, test.code)
+				}
+			} else if string(payload) != test.payload {
+				t.Errorf("payload = %q, want %q", payload, test.payload)
+			}
+		})
+	}
+}
+
+func TestWebSocketCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		allow   bool
+	}{
+		{"no origin", nil, "", true},
+		{"same origin", nil, "https://api.example.com", true},
+		{"cross origin", nil, "https://evil.com", false},
+		{"cors origin", []string{"https://app.example.com"}, "https://app.example.com", true},
+		{"cors wildcard not accepted", []string{"*"}, "https://evil.com", false},
+		{"cors subdomain", []string{"https://*.example.com"}, "https://app.example.com", true},
+		{"cors subdomain wrong scheme", []string{"https://*.example.com"}, "http://app.example.com", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var router *Router
+			testServer(func(server *Server) {
+				router = server.NewWebSocketRouter(&Doc{Path: "/ws"},
+					func(conn *WebSocketConn, message map[string]interface{}) bool {
+						return true
+					})
+				if test.origins != nil {
+					router.SetCORS(&CORS{Origins: test.origins})
+				}
+			})
+			req := testRequest("GET", "https://api.example.com/ws", nil)
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			if allow := router.checkOrigin(req); allow != test.allow {
+				t.Errorf("checkOrigin = %v, want %v", allow, test.allow)
+			}
+		})
+	}
+
+	// 握手被拒绝时返回403
+	_, ts := echoServer()
+	defer ts.Close()
+	ws, resp := dialWebSocket(t, ts, "/ws", http.Header{"Origin": {"https://evil.com"}})
+	ws.conn.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("cross origin handshake = %d, want 403", resp.StatusCode)
+	}
+}
+
+func TestWebSocketShutdown(t *testing.T) {
+	server, ts := echoServer()
+	defer ts.Close()
+	server.httpServer = ts.Config
+	server.stopped = make(chan struct{})
+
+	ws, _ := dialWebSocket(t, ts, "/ws", nil)
+	defer ws.conn.Close()
+	ws.send(wsText, true, true, []byte(`{"a":1}`))
+	ws.read()
+	if n := server.webSockets.size(); n != 1 {
+		t.Fatalf("tracked connections = %d, want 1", n)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		server.Shutdown()
+		close(done)
+	}()
+	opcode, payload := ws.read()
+	if opcode != wsClose || closeCode(payload) != 1001 {
+		t.Errorf("got opcode %x code %d, want close 1001", opcode, closeCode(payload))
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not return")
+	}
+	if n := server.webSockets.size(); n != 0 {
+		t.Errorf("connections after shutdown = %d, want 0", n)
+	}
+}